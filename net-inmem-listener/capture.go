@@ -0,0 +1,220 @@
+package testlistener
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Capture records the traffic of connections, see WithCapture.
+type Capture struct {
+	mu      sync.Mutex
+	records []Record
+	conns   int // number of the captured connections
+}
+
+// Record is a single event of a captured connection.
+type Record struct {
+	Time time.Time
+	// Conn is the number of the connection in the capture, starting from 1.
+	// The connections of different listeners, sharing the capture, get
+	// different numbers.
+	Conn int
+	Kind RecordKind
+	// FromClient tells whether the client end of the connection has
+	// written the Data or closed the connection.
+	FromClient bool
+	Data       []byte
+	// Client and Server are the addresses of the connection's ends.
+	Client net.Addr
+	Server net.Addr
+}
+
+// RecordKind is the kind of a captured event.
+type RecordKind int
+
+const (
+	// RecordOpen is recorded when the connection is created.
+	RecordOpen RecordKind = iota
+	// RecordData is recorded when one of the ends writes the data.
+	RecordData
+	// RecordClose is recorded when one of the ends is closed, or
+	// its writing side is shut down with CloseWrite.
+	RecordClose
+)
+
+// WithCapture records the traffic of connections into c. A single Capture
+// can be shared by several listeners or connections.
+func WithCapture(c *Capture) Option {
+	return func(cfg *config) {
+		cfg.capture = c
+	}
+}
+
+// Records returns the captured events in the order they happened.
+func (c *Capture) Records() []Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := make([]Record, 0, len(c.records))
+	for _, r := range c.records {
+		// Skip the writes, which are in progress or didn't write anything
+		if r.Kind == RecordData && len(r.Data) == 0 {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// record appends r to the captured events and returns its index.
+func (c *Capture) record(r Record) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.Time = time.Now()
+	c.records = append(c.records, r)
+	return len(c.records) - 1
+}
+
+func (c *Capture) setData(i int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records[i].Data = data
+}
+
+// WriteTranscript writes the human-readable transcript of the captured
+// traffic to w. The data is written as hex dumps, and the times are relative
+// to the first record.
+func (c *Capture) WriteTranscript(w io.Writer) error {
+	records := c.Records()
+	if len(records) == 0 {
+		return nil
+	}
+
+	start := records[0].Time
+	for _, r := range records {
+		src, dst := "client", "server"
+		if !r.FromClient {
+			src, dst = dst, src
+		}
+
+		var err error
+		switch r.Kind {
+		case RecordOpen:
+			_, err = fmt.Fprintf(w, "%12s #%d open %s -> %s\n", r.Time.Sub(start), r.Conn, r.Client, r.Server)
+		case RecordData:
+			_, err = fmt.Fprintf(w, "%12s #%d %s -> %s %d bytes\n%s", r.Time.Sub(start), r.Conn, src, dst, len(r.Data), indent(hex.Dump(r.Data)))
+		case RecordClose:
+			_, err = fmt.Fprintf(w, "%12s #%d %s close\n", r.Time.Sub(start), r.Conn, src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indent prefixes every line of s with a tab.
+func indent(s string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line != "" {
+			b.WriteString("\t")
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+// LogOnFailure registers a test cleanup, which logs the transcript of
+// the captured traffic, if the test has failed.
+func (c *Capture) LogOnFailure(tb testing.TB) {
+	tb.Helper()
+	tb.Cleanup(func() {
+		if !tb.Failed() {
+			return
+		}
+		var buf strings.Builder
+		c.WriteTranscript(&buf)
+		tb.Logf("testlistener: captured traffic:\n%s", buf.String())
+	})
+}
+
+// wrap wraps both ends of the connection to record their traffic.
+func (c *Capture) wrap(client, server net.Conn) (net.Conn, net.Conn) {
+	c.mu.Lock()
+	c.conns++
+	id := c.conns
+	c.mu.Unlock()
+
+	c.record(Record{
+		Conn:       id,
+		Kind:       RecordOpen,
+		FromClient: true,
+		Client:     client.LocalAddr(),
+		Server:     server.LocalAddr(),
+	})
+	tap := func(conn net.Conn, fromClient bool) net.Conn {
+		return &captureConn{
+			Conn:    conn,
+			capture: c,
+			tmpl: Record{
+				Conn:       id,
+				FromClient: fromClient,
+				Client:     client.LocalAddr(),
+				Server:     server.LocalAddr(),
+			},
+		}
+	}
+	return tap(client, true), tap(server, false)
+}
+
+// captureConn wraps a net.Conn, recording what's written to it.
+type captureConn struct {
+	net.Conn
+	capture *Capture
+	tmpl    Record
+	once    sync.Once
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	// Record the write before it happens, so the peer's response to the data
+	// can't appear in the capture before the data itself
+	r := c.tmpl
+	r.Kind = RecordData
+	i := c.capture.record(r)
+
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.capture.setData(i, append([]byte(nil), b[:n]...))
+	}
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	c.recordClose()
+	return c.Conn.Close()
+}
+
+func (c *captureConn) CloseRead() error {
+	return closeRead(c.Conn)
+}
+
+func (c *captureConn) CloseWrite() error {
+	c.recordClose()
+	return closeWrite(c.Conn)
+}
+
+// recordClose records the end closing its writing side, which happens once
+// either on CloseWrite or Close.
+func (c *captureConn) recordClose() {
+	c.once.Do(func() {
+		r := c.tmpl
+		r.Kind = RecordClose
+		c.capture.record(r)
+	})
+}
@@ -0,0 +1,145 @@
+package testlistener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	var capture Capture
+	l := NewListener(WithBuffer(1024), WithCapture(&capture))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l)
+	client.Write([]byte("ping"))
+	io.ReadFull(server, make([]byte, 4))
+	server.Write([]byte("pong"))
+	io.ReadFull(client, make([]byte, 4))
+	client.Close()
+	server.Close()
+
+	records := capture.Records()
+	want := []struct {
+		kind       RecordKind
+		fromClient bool
+		data       string
+	}{
+		{RecordOpen, true, ""},
+		{RecordData, true, "ping"},
+		{RecordData, false, "pong"},
+		{RecordClose, true, ""},
+		{RecordClose, false, ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, r := range records {
+		w := want[i]
+		if r.Conn != 1 || r.Kind != w.kind || r.FromClient != w.fromClient || string(r.Data) != w.data {
+			t.Errorf("record %d: got %+v, want %+v", i, r, w)
+		}
+	}
+
+	var transcript strings.Builder
+	if err := capture.WriteTranscript(&transcript); err != nil {
+		t.Fatalf("WriteTranscript failed: %v", err)
+	}
+	for _, s := range []string{"#1 open", "#1 client -> server 4 bytes", "|ping|", "#1 server -> client 4 bytes", "|pong|", "#1 client close"} {
+		if !strings.Contains(transcript.String(), s) {
+			t.Errorf("transcript doesn't contain %q:\n%s", s, transcript.String())
+		}
+	}
+}
+
+func TestCapture_WritePcapng(t *testing.T) {
+	var capture Capture
+	l := NewListener(WithBuffer(1024), WithCapture(&capture))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l)
+	client.Write([]byte("hello"))
+	client.Close()
+	server.Close()
+
+	var buf bytes.Buffer
+	if err := capture.WritePcapng(&buf); err != nil {
+		t.Fatalf("WritePcapng failed: %v", err)
+	}
+
+	var (
+		types   []uint32
+		packets [][]byte
+	)
+	data := buf.Bytes()
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		typ := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("invalid block length %d", length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4:]); trailer != length {
+			t.Fatalf("block trailer length %d, want %d", trailer, length)
+		}
+		if typ == pcapngEnhancedPacket {
+			caplen := binary.LittleEndian.Uint32(data[20:])
+			packets = append(packets, data[28:28+caplen])
+		}
+		types = append(types, typ)
+		data = data[length:]
+	}
+
+	if types[0] != pcapngSectionHeader || types[1] != pcapngInterfaceDesc {
+		t.Fatalf("got blocks %x, want section header and interface description first", types)
+	}
+	// SYN, SYN-ACK, ACK, data, FIN from the client and FIN from the server
+	if len(packets) != 6 {
+		t.Fatalf("got %d packets, want %d", len(packets), 6)
+	}
+	for i, pkt := range packets {
+		if checksum(pkt[:20], 0) != 0 {
+			t.Errorf("packet %d: invalid IP header checksum", i)
+		}
+		if got := int(binary.BigEndian.Uint16(pkt[2:])); got != len(pkt) {
+			t.Errorf("packet %d: IP total length %d, want %d", i, got, len(pkt))
+		}
+	}
+	if flags := packets[0][20+13]; flags != tcpSYN {
+		t.Errorf("first packet flags %#x, want SYN", flags)
+	}
+	if payload := packets[3][40:]; string(payload) != "hello" {
+		t.Errorf("data packet payload %q, want %q", payload, "hello")
+	}
+}
+
+func TestCapture_Shared(t *testing.T) {
+	var capture Capture
+	for _, msg := range []string{"one", "two"} {
+		l := NewListener(WithBuffer(1024), WithCapture(&capture))
+		client, server := connectPair(t, l)
+		client.Write([]byte(msg))
+		client.Close()
+		server.Close()
+		l.Close()
+	}
+
+	// Both connections are #1 in their listeners, but not in the capture
+	var conns []int
+	for _, r := range capture.Records() {
+		if r.Kind == RecordData {
+			conns = append(conns, r.Conn)
+		}
+	}
+	if len(conns) != 2 || conns[0] != 1 || conns[1] != 2 {
+		t.Errorf("got data of conns %v, want [1 2]", conns)
+	}
+}
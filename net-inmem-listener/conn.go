@@ -0,0 +1,514 @@
+package testlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// pipeConns creates a pair of connected conns, much like net.Pipe.
+// With a zero size, the conns are synchronous: a Write blocks until the peer
+// reads all of its data, as with net.Pipe. Otherwise, a Write returns as soon
+// as its data fits into the peer's receive buffer of the given size, without
+// waiting for the peer to Read it.
+// If link isn't nil, the data in each direction is delayed according to it.
+func pipeConns(size int, link *Link) (*conn, *conn) {
+	p1 := newPipe(size, link)
+	p2 := newPipe(size, link)
+	c1 := &conn{rx: p1, tx: p2}
+	c2 := &conn{rx: p2, tx: p1}
+	return c1, c2
+}
+
+// halfCloser is implemented by connections, which support half-close,
+// such as *net.TCPConn.
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+var (
+	_ halfCloser = (*net.TCPConn)(nil)
+	_ halfCloser = (*conn)(nil)
+)
+
+// closeRead shuts down the reading side of c, if c supports half-close.
+func closeRead(c net.Conn) error {
+	if hc, ok := c.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return errors.ErrUnsupported
+}
+
+// closeWrite shuts down the writing side of c, if c supports half-close.
+func closeWrite(c net.Conn) error {
+	if hc, ok := c.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// conn implements net.Conn on top of two unidirectional pipes.
+type conn struct {
+	rx     *pipe // data from the peer
+	tx     *pipe // data to the peer
+	closed atomic.Bool
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return c.rx.read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return c.tx.write(b)
+}
+
+func (c *conn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.rx.closeRead()
+	c.tx.closeWrite()
+	return nil
+}
+
+// CloseRead shuts down the reading side of the connection, like
+// *net.TCPConn's CloseRead does. After that, Read returns io.EOF and
+// the data, which the peer writes, is discarded.
+func (c *conn) CloseRead() error {
+	if c.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	c.rx.shutdownRead()
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the connection, like
+// *net.TCPConn's CloseWrite does. The peer reads io.EOF, once it reads
+// all the data written before, while the other direction stays open.
+func (c *conn) CloseWrite() error {
+	if c.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	c.tx.closeWrite()
+	return nil
+}
+
+// stall stops or resumes the delivery of data in both directions
+// of the connection.
+func (c *conn) stall(stalled bool) {
+	c.rx.stall(stalled)
+	c.tx.stall(stalled)
+}
+
+// reset breaks both directions of the connection, so Read and Write
+// on both ends fail with syscall.ECONNRESET.
+func (c *conn) reset() {
+	c.rx.reset()
+	c.tx.reset()
+}
+
+func (c *conn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *conn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	c.rx.rdeadline.set(t)
+	c.tx.wdeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	c.rx.rdeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	c.tx.wdeadline.set(t)
+	return nil
+}
+
+// addrConn wraps a net.Conn, overriding its addresses.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+// withAddrs makes the client and server ends of a connection report
+// the given addresses. A nil address keeps the conn's own one.
+func withAddrs(client, server net.Conn, clientAddr, serverAddr net.Addr) (net.Conn, net.Conn) {
+	if clientAddr == nil {
+		clientAddr = client.LocalAddr()
+	}
+	if serverAddr == nil {
+		serverAddr = server.LocalAddr()
+	}
+	return &addrConn{Conn: client, local: clientAddr, remote: serverAddr},
+		&addrConn{Conn: server, local: serverAddr, remote: clientAddr}
+}
+
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+func (c *addrConn) CloseRead() error     { return closeRead(c.Conn) }
+func (c *addrConn) CloseWrite() error    { return closeWrite(c.Conn) }
+
+// pipeAddr implements net.Addr the same way net.Pipe's addresses do.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipe is a unidirectional byte stream with a bounded buffer.
+type pipe struct {
+	mu       sync.Mutex
+	segs     []segment
+	buffered int  // total length of segs
+	size     int  // zero makes the pipe synchronous
+	writing  bool // a synchronous write is in progress
+	rclosed  bool // reading end is closed, nothing will consume the data
+	rshut    bool // reading end is shut down, the data is discarded
+	wclosed  bool // writing end is closed, reader gets io.EOF once segs are drained
+	stalled  bool // nothing is delivered to the reader until the pipe resumes
+	broken   bool // the pipe is reset, both ends get syscall.ECONNRESET
+	eofAt    time.Time
+	link     *linkState
+	changed  chan struct{}
+
+	rdeadline deadline
+	wdeadline deadline
+}
+
+// segment is a chunk of written data, which becomes readable at the given time.
+type segment struct {
+	data []byte
+	at   time.Time
+}
+
+func newPipe(size int, link *Link) *pipe {
+	p := &pipe{
+		size:      size,
+		changed:   make(chan struct{}),
+		rdeadline: makeDeadline(),
+		wdeadline: makeDeadline(),
+	}
+	if link != nil {
+		p.link = &linkState{Link: *link}
+	}
+	return p
+}
+
+// notify wakes up everyone waiting for the pipe's state to change.
+// The caller must hold p.mu.
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wait blocks until the pipe's state changes, the deadline is exceeded or,
+// unless it's zero, the time reaches next. The caller must hold p.mu,
+// which wait releases while it's blocked.
+func (p *pipe) wait(deadline *deadline, next time.Time) error {
+	changed := p.changed
+	p.mu.Unlock()
+	defer p.mu.Lock()
+
+	var timeout <-chan time.Time
+	if !next.IsZero() {
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-changed:
+	case <-timeout:
+	case <-deadline.wait():
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		// The earliest time the pipe's state changes without anyone's action
+		var next time.Time
+
+		now := time.Now()
+		switch {
+		case p.rclosed:
+			return 0, io.ErrClosedPipe
+		case p.broken:
+			return 0, resetErr("read")
+		case isClosedChan(p.rdeadline.wait()):
+			return 0, os.ErrDeadlineExceeded
+		case p.rshut:
+			return 0, io.EOF
+		case p.stalled:
+			// Wait for the pipe to resume
+		case len(p.segs) > 0:
+			if next = p.segs[0].at; next.After(now) {
+				break
+			}
+			n := p.consume(b, now)
+			p.notify()
+			return n, nil
+		case p.wclosed:
+			if next = p.eofAt; next.After(now) {
+				break
+			}
+			return 0, io.EOF
+		case len(b) == 0:
+			return 0, nil
+		}
+
+		if err := p.wait(&p.rdeadline, next); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// consume copies the data of segments, which are readable by now, into b.
+// The caller must hold p.mu.
+func (p *pipe) consume(b []byte, now time.Time) (n int) {
+	for len(p.segs) > 0 && n < len(b) && !p.segs[0].at.After(now) {
+		seg := &p.segs[0]
+		m := copy(b[n:], seg.data)
+		seg.data = seg.data[m:]
+		if len(seg.data) == 0 {
+			p.segs = p.segs[1:]
+		}
+		n += m
+	}
+	p.buffered -= n
+	return n
+}
+
+func (p *pipe) write(b []byte) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		switch {
+		case p.broken:
+			return n, resetErr("write")
+		case p.wclosed, p.rclosed:
+			return n, io.ErrClosedPipe
+		case isClosedChan(p.wdeadline.wait()):
+			return n, os.ErrDeadlineExceeded
+		case p.rshut:
+			// Nobody reads the data, so it's discarded right away
+			return n + len(b), nil
+		case len(b) == 0:
+			return n, nil
+		case p.size == 0 && !p.writing:
+			return p.writeSync(b)
+		case p.size > 0 && p.buffered < p.size:
+			m := min(len(b), p.size-p.buffered)
+			p.enqueue(b[:m])
+			b = b[m:]
+			n += m
+			continue
+		}
+
+		if err := p.wait(&p.wdeadline, time.Time{}); err != nil {
+			return n, err
+		}
+	}
+}
+
+// writeSync writes b to the synchronous pipe and waits until the reader
+// consumes all of it. If the write is aborted, the data, which the reader
+// hasn't consumed, is dropped. The caller must hold p.mu.
+func (p *pipe) writeSync(b []byte) (int, error) {
+	p.writing = true
+	defer func() {
+		p.writing = false
+		p.notify()
+	}()
+
+	p.enqueue(b)
+	for {
+		var err error
+		switch {
+		case p.buffered == 0:
+			return len(b), nil
+		case p.broken:
+			err = resetErr("write")
+		case p.wclosed, p.rclosed:
+			err = io.ErrClosedPipe
+		case p.rshut:
+			err = nil
+		default:
+			err = p.wait(&p.wdeadline, time.Time{})
+			if err == nil {
+				continue
+			}
+		}
+
+		n := len(b) - p.buffered
+		p.segs = nil
+		p.buffered = 0
+		if err == nil {
+			// The reader was shut down, so the rest of data is discarded
+			n = len(b)
+		}
+		return n, err
+	}
+}
+
+// enqueue splits b into segments and appends them to the pipe.
+// The caller must hold p.mu.
+func (p *pipe) enqueue(b []byte) {
+	for len(b) > 0 {
+		m := min(len(b), maxSegmentSize)
+		seg := segment{
+			data: append([]byte(nil), b[:m]...),
+		}
+		if p.link != nil {
+			seg.at = p.link.schedule(m, time.Now())
+		}
+		p.segs = append(p.segs, seg)
+		p.buffered += m
+		b = b[m:]
+	}
+	p.notify()
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rclosed = true
+	p.segs = nil
+	p.buffered = 0
+	p.notify()
+}
+
+func (p *pipe) shutdownRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rshut = true
+	p.segs = nil
+	p.buffered = 0
+	p.notify()
+}
+
+func (p *pipe) stall(stalled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stalled = stalled
+	p.notify()
+}
+
+func (p *pipe) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.broken = true
+	p.segs = nil
+	p.buffered = 0
+	p.notify()
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wclosed {
+		return
+	}
+	p.wclosed = true
+	if p.link != nil {
+		// FIN travels through the link like any other segment
+		p.eofAt = p.link.schedule(0, time.Now())
+	}
+	p.notify()
+}
+
+// resetErr returns the error of the op on a reset pipe.
+func resetErr(op string) error {
+	return &net.OpError{Op: op, Net: "pipe", Err: syscall.ECONNRESET}
+}
+
+// deadline is an abstraction for handling timeouts, modelled after net.Pipe's.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // must be non-nil
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the point in time when the deadline will time out.
+// A zero value for t prevents timeout.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	// Time is zero, then there is no deadline.
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	// Time in the future, setup a timer to cancel in the future.
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	// Time in the past, so close immediately.
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+//go:build simtest
+
+package testlistener
+
+import (
+	"io"
+	"testing"
+	"testing/synctest"
+)
+
+func TestPipeConns_syncWrite(t *testing.T) {
+	synctest.Run(func() {
+		c1, c2 := pipeConns(0, nil)
+		defer c1.Close()
+		defer c2.Close()
+
+		// The write returns only after the peer reads all of its data
+		written := make(chan int, 1)
+		go func() {
+			n, _ := c1.Write([]byte("hello"))
+			written <- n
+		}()
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(c2, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+
+		// Wait for the writer to block on the rest of its data
+		synctest.Wait()
+		select {
+		case n := <-written:
+			t.Fatalf("Write returned %d before the data was read", n)
+		default:
+		}
+
+		if _, err := io.ReadFull(c2, buf[:2]); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if n := <-written; n != 5 {
+			t.Errorf("Write wrote %d bytes, want %d", n, 5)
+		}
+	})
+}
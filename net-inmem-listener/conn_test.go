@@ -0,0 +1,190 @@
+package testlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPipeConns_writeBeforeRead(t *testing.T) {
+	c1, c2 := pipeConns(16, nil)
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	// Both sides write before reading, which deadlocks with net.Pipe
+	for _, c := range []net.Conn{c1, c2} {
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	for _, c := range []net.Conn{c1, c2} {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Errorf("Read %q, want %q", buf, "ping")
+		}
+	}
+}
+
+func TestPipeConns_fullBuffer(t *testing.T) {
+	c1, c2 := pipeConns(4, nil)
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	c1.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err := c1.Write([]byte("hello"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if n != 4 {
+		t.Errorf("Write wrote %d bytes, want %d", n, 4)
+	}
+}
+
+func TestPipeConns_Close(t *testing.T) {
+	c1, c2 := pipeConns(16, nil)
+
+	if _, err := c1.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := c1.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The peer still reads the buffered data before io.EOF
+	got, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "bye" {
+		t.Errorf("Read %q, want %q", got, "bye")
+	}
+
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Read after close got error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if _, err := c2.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Write to closed peer got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestPipeConns_ReadDeadline(t *testing.T) {
+	c1, c2 := pipeConns(16, nil)
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	c1.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := c1.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// Clearing the deadline makes the conn usable again
+	c1.SetReadDeadline(time.Time{})
+	c2.Write([]byte("x"))
+	if _, err := c1.Read(make([]byte, 1)); err != nil {
+		t.Errorf("Read failed: %v", err)
+	}
+}
+
+func TestPipeConns_sync(t *testing.T) {
+	c1, c2 := pipeConns(0, nil)
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	// Nobody reads, so the write times out without writing anything
+	c1.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	if n, err := c1.Write([]byte("hello")); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write got (%d, %v), want (0, %v)", n, err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestListener_HalfClose(t *testing.T) {
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		client, server := connectPair(t, l)
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+
+		hc, ok := client.(interface{ CloseWrite() error })
+		if !ok {
+			t.Fatalf("%T doesn't implement CloseWrite", client)
+		}
+
+		// The server reads the request until EOF and still writes the response
+		go func() {
+			req, _ := io.ReadAll(server)
+			server.Write(append([]byte("re: "), req...))
+			server.Close()
+		}()
+
+		if _, err := client.Write([]byte("request")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := hc.CloseWrite(); err != nil {
+			t.Fatalf("CloseWrite failed: %v", err)
+		}
+		if _, err := client.Write([]byte("x")); err == nil {
+			t.Error("Write after CloseWrite succeeded")
+		}
+
+		resp, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if want := "re: request"; string(resp) != want {
+			t.Errorf("Read %q, want %q", resp, want)
+		}
+	})
+}
+
+func TestListener_CloseRead(t *testing.T) {
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		client, server := connectPair(t, l)
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+
+		if err := server.(interface{ CloseRead() error }).CloseRead(); err != nil {
+			t.Fatalf("CloseRead failed: %v", err)
+		}
+		if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Read after CloseRead got error %v, want %v", err, io.EOF)
+		}
+
+		// The data written to the shut down side is discarded
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Errorf("Write failed: %v", err)
+		}
+		// The other direction stays open
+		go server.Write([]byte("x"))
+		if _, err := io.ReadFull(client, make([]byte, 1)); err != nil {
+			t.Errorf("Read failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package testlistener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestListener_DialContext_HTTP(t *testing.T) {
+	l := NewListener()
+
+	// Server's Close closes the listener
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello "+r.URL.Path)
+		}),
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: l.DialContext,
+		},
+	}
+	resp, err := client.Get("http://example.com/foo")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Read body failed: %v", err)
+	}
+	if want := "hello /foo"; string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+}
+
+func TestListener_DialContext_cancel(t *testing.T) {
+	l := NewListener()
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Nobody accepts, so the dial must be aborted by the context
+	_, err := l.DialContext(ctx, "tcp", "addr")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialContext got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestListener_DialContext_RemoteAddr(t *testing.T) {
+	raddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 54321}
+	l := NewListener(WithRemoteAddr(raddr))
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		}),
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: l.DialContext,
+		},
+	}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if want := raddr.String(); string(body) != want {
+		t.Errorf("got RemoteAddr %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,303 @@
+package testlistener
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Fault is a failure injected into one end of a connection.
+// Offsets of faults are counted in bytes, read or written through that end.
+type Fault struct {
+	kind faultKind
+	at   int64
+	err  error
+}
+
+type faultKind int
+
+const (
+	faultReset faultKind = iota
+	faultReadErr
+	faultWriteErr
+	faultShortWrite
+	faultHang
+)
+
+// ResetAfter resets the connection once the end has written n bytes.
+// After that, Read and Write on both ends fail with syscall.ECONNRESET.
+func ResetAfter(n int64) Fault {
+	return Fault{kind: faultReset, at: n}
+}
+
+// ReadError makes Read return err once the end has read n bytes.
+// A nil err means io.ErrUnexpectedEOF, so Read never returns (0, nil).
+func ReadError(n int64, err error) Fault {
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return Fault{kind: faultReadErr, at: n, err: err}
+}
+
+// WriteError makes Write return err once the end has written n bytes.
+// A nil err means io.ErrUnexpectedEOF, so a short Write never succeeds.
+func WriteError(n int64, err error) Fault {
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return Fault{kind: faultWriteErr, at: n, err: err}
+}
+
+// ShortWrites makes every Write deliver at most n bytes and return
+// io.ErrShortWrite if it couldn't deliver more.
+func ShortWrites(n int) Fault {
+	return Fault{kind: faultShortWrite, at: int64(n)}
+}
+
+// HangAfter makes Read and Write block once the end has read and written
+// n bytes in total. Blocked calls return only when the deadline is exceeded
+// or the connection is closed.
+func HangAfter(n int64) Fault {
+	return Fault{kind: faultHang, at: n}
+}
+
+// FaultRule injects faults into connections, created by a listener.
+type FaultRule struct {
+	// Match selects connections by their sequence number in the listener,
+	// starting from 1. A nil Match selects all connections.
+	Match func(seq int) bool
+	// Client and Server are the faults of the client and server ends.
+	Client []Fault
+	Server []Fault
+}
+
+// WithFaults injects faults into the client and server ends of a connection.
+func WithFaults(client, server []Fault) Option {
+	return WithFaultRules(FaultRule{Client: client, Server: server})
+}
+
+// WithFaultRules injects faults into the connections matched by the rules.
+func WithFaultRules(rules ...FaultRule) Option {
+	return func(cfg *config) {
+		cfg.faultRules = append(cfg.faultRules, rules...)
+	}
+}
+
+// injectFaults wraps both ends of the connection with the seq sequence number,
+// if any of the rules match it.
+func injectFaults(rules []FaultRule, seq int, client, server net.Conn) (net.Conn, net.Conn) {
+	var cfaults, sfaults []Fault
+	for _, rule := range rules {
+		if rule.Match == nil || rule.Match(seq) {
+			cfaults = append(cfaults, rule.Client...)
+			sfaults = append(sfaults, rule.Server...)
+		}
+	}
+	if len(cfaults) == 0 && len(sfaults) == 0 {
+		return client, server
+	}
+
+	reset := &connReset{done: make(chan struct{})}
+	return newFaultConn(client, cfaults, reset), newFaultConn(server, sfaults, reset)
+}
+
+// connReset is the reset state shared by both ends of a connection.
+type connReset struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (r *connReset) reset(conns ...net.Conn) {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (r *connReset) isReset() bool {
+	return isClosedChan(r.done)
+}
+
+// faultConn wraps a net.Conn, injecting faults into its reads and writes.
+type faultConn struct {
+	net.Conn
+	faults []Fault
+	reset  *connReset
+
+	nread    atomic.Int64
+	nwritten atomic.Int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	rdeadline deadline
+	wdeadline deadline
+}
+
+func newFaultConn(c net.Conn, faults []Fault, reset *connReset) *faultConn {
+	return &faultConn{
+		Conn:      c,
+		faults:    faults,
+		reset:     reset,
+		closed:    make(chan struct{}),
+		rdeadline: makeDeadline(),
+		wdeadline: makeDeadline(),
+	}
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.reset.isReset() {
+		return 0, c.resetErr("read")
+	}
+
+	nread := c.nread.Load()
+	for _, f := range c.faults {
+		var left int64
+		switch f.kind {
+		case faultReadErr:
+			left = f.at - nread
+		case faultHang:
+			left = f.at - nread - c.nwritten.Load()
+		default:
+			continue
+		}
+		if left <= 0 {
+			if f.kind == faultHang {
+				return 0, c.hang(c.rdeadline.wait(), "read")
+			}
+			return 0, f.err
+		}
+		if int64(len(b)) > left {
+			b = b[:left]
+		}
+	}
+
+	n, err := c.Conn.Read(b)
+	c.nread.Add(int64(n))
+	if err != nil && c.reset.isReset() {
+		err = c.resetErr("read")
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(b []byte) (n int, err error) {
+	if c.reset.isReset() {
+		return 0, c.resetErr("write")
+	}
+
+	// Find the first fault on the way of the data
+	chunk := int64(len(b))
+	var fault *Fault
+	for i, f := range c.faults {
+		var left int64
+		switch f.kind {
+		case faultReset, faultWriteErr:
+			left = f.at - c.nwritten.Load()
+		case faultHang:
+			left = f.at - c.nwritten.Load() - c.nread.Load()
+		case faultShortWrite:
+			left = f.at
+		default:
+			continue
+		}
+		if left < chunk || (left == chunk && f.kind != faultShortWrite) {
+			chunk = max(left, 0)
+			fault = &c.faults[i]
+		}
+	}
+
+	if chunk > 0 {
+		m, err := c.Conn.Write(b[:chunk])
+		n += m
+		c.nwritten.Add(int64(m))
+		if err != nil {
+			if c.reset.isReset() {
+				err = c.resetErr("write")
+			}
+			return n, err
+		}
+		b = b[m:]
+	}
+
+	if fault == nil {
+		return n, nil
+	}
+	switch fault.kind {
+	case faultReset:
+		c.reset.reset(c.Conn)
+		if len(b) == 0 {
+			return n, nil
+		}
+		return n, c.resetErr("write")
+	case faultWriteErr:
+		if len(b) == 0 && chunk > 0 {
+			// The error hits the next Write
+			return n, nil
+		}
+		return n, fault.err
+	case faultShortWrite:
+		if len(b) == 0 {
+			return n, nil
+		}
+		return n, io.ErrShortWrite
+	case faultHang:
+		if len(b) == 0 && chunk > 0 {
+			return n, nil
+		}
+		return n, c.hang(c.wdeadline.wait(), "write")
+	}
+	return n, nil
+}
+
+// hang blocks until the conn is closed or reset, or the deadline is exceeded.
+func (c *faultConn) hang(deadline <-chan struct{}, op string) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case <-c.reset.done:
+		return c.resetErr(op)
+	case <-deadline:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *faultConn) resetErr(op string) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    syscall.ECONNRESET,
+	}
+}
+
+func (c *faultConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+func (c *faultConn) CloseRead() error  { return closeRead(c.Conn) }
+func (c *faultConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+func (c *faultConn) SetDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	c.wdeadline.set(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *faultConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline.set(t)
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,205 @@
+package testlistener
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFault_ResetAfter(t *testing.T) {
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		client, server := connectPair(t, l, WithFaults([]Fault{ResetAfter(3)}, nil))
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+
+		go io.Copy(io.Discard, server)
+
+		n, err := client.Write([]byte("hello"))
+		if !errors.Is(err, syscall.ECONNRESET) {
+			t.Errorf("Write got error %v, want %v", err, syscall.ECONNRESET)
+		}
+		if n != 3 {
+			t.Errorf("Write wrote %d bytes, want %d", n, 3)
+		}
+
+		if _, err := server.Write([]byte("x")); !errors.Is(err, syscall.ECONNRESET) {
+			t.Errorf("peer Write got error %v, want %v", err, syscall.ECONNRESET)
+		}
+	})
+}
+
+func TestFault_ReadError(t *testing.T) {
+	l := NewListener(WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	errBoom := errors.New("boom")
+	client, server := connectPair(t, l, WithFaults(nil, []Fault{ReadError(4, errBoom)}))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	client.Write([]byte("hello world"))
+
+	got, err := io.ReadAll(server)
+	if !errors.Is(err, errBoom) {
+		t.Errorf("ReadAll got error %v, want %v", err, errBoom)
+	}
+	if string(got) != "hell" {
+		t.Errorf("ReadAll read %q, want %q", got, "hell")
+	}
+}
+
+func TestFault_WriteError(t *testing.T) {
+	l := NewListener(WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	errBoom := errors.New("boom")
+	client, server := connectPair(t, l, WithFaults([]Fault{WriteError(5, errBoom)}, nil))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := client.Write([]byte("world")); !errors.Is(err, errBoom) {
+		t.Errorf("Write got error %v, want %v", err, errBoom)
+	}
+}
+
+func TestFault_ShortWrites(t *testing.T) {
+	l := NewListener(WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l, WithFaults([]Fault{ShortWrites(2)}, nil))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	n, err := client.Write([]byte("hello"))
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Write got error %v, want %v", err, io.ErrShortWrite)
+	}
+	if n != 2 {
+		t.Errorf("Write wrote %d bytes, want %d", n, 2)
+	}
+}
+
+func TestFault_HangAfter(t *testing.T) {
+	l := NewListener(WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l, WithFaults(nil, []Fault{HangAfter(0)}))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	client.Write([]byte("hello"))
+
+	server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := server.Read(make([]byte, 5)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestFault_Rules(t *testing.T) {
+	errBoom := errors.New("boom")
+	l := NewListener(WithBuffer(1024), WithFaultRules(FaultRule{
+		Match:  func(seq int) bool { return seq == 2 },
+		Client: []Fault{WriteError(0, errBoom)},
+	}))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	for seq := 1; seq <= 3; seq++ {
+		client, server := connectPair(t, l)
+		_, err := client.Write([]byte("x"))
+		if seq == 2 && !errors.Is(err, errBoom) {
+			t.Errorf("conn %d: Write got error %v, want %v", seq, err, errBoom)
+		}
+		if seq != 2 && err != nil {
+			t.Errorf("conn %d: Write failed: %v", seq, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestFault_ReadErrorNil(t *testing.T) {
+	l := NewListener(WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l, WithFaults(nil, []Fault{ReadError(0, nil)}))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	if _, err := server.Read(make([]byte, 5)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestFault_ConnectRules(t *testing.T) {
+	// The listener's rules leave spare capacity, which the faults
+	// of concurrent Connect calls must not share.
+	never := FaultRule{Match: func(int) bool { return false }}
+	const n = 8
+	l := NewListener(WithBuffer(1024), WithBacklog(n), WithFaultRules(never), WithFaultRules(never), WithFaultRules(never))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	var errs [n]error
+	clients := make(chan net.Conn, n)
+	var wg sync.WaitGroup
+	for i := range errs {
+		errs[i] = fmt.Errorf("boom %d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := l.Connect(WithFaults([]Fault{WriteError(0, errs[i])}, nil))
+			if err != nil {
+				t.Errorf("Connect failed: %v", err)
+				return
+			}
+			if _, err := client.Write([]byte("x")); !errors.Is(err, errs[i]) {
+				t.Errorf("conn %d: Write got error %v, want %v", i, err, errs[i])
+			}
+			clients <- client
+		}()
+	}
+	wg.Wait()
+	close(clients)
+	for client := range clients {
+		client.Close()
+	}
+}
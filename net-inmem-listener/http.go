@@ -0,0 +1,84 @@
+package testlistener
+
+import (
+	"net/http"
+)
+
+// HTTPServer is an HTTP server, which serves on an in-memory Listener.
+// It's similar to httptest.Server, but doesn't use the host's network.
+//
+// The server supports both HTTP/1.1 and HTTP/2 with prior knowledge (h2c).
+// Because HTTP/2 peers write without waiting for each other, the server's
+// connections are buffered, unless the options set WithBuffer.
+type HTTPServer struct {
+	// URL is the base URL of the server, formed from the listener's address.
+	URL string
+	// Listener is the listener, which the server serves on.
+	Listener *Listener
+	// Config is the server's configuration.
+	Config *http.Server
+
+	client    *http.Client
+	h2cClient *http.Client
+}
+
+// NewHTTPServer starts a new server with the handler. The options
+// configure the server's listener.
+func NewHTTPServer(handler http.Handler, opts ...Option) *HTTPServer {
+	opts = append([]Option{WithBuffer(defaultBufSize)}, opts...)
+	l := NewListener(opts...)
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	s := &HTTPServer{
+		URL:      "http://" + l.Addr().String(),
+		Listener: l,
+		Config: &http.Server{
+			Handler:   handler,
+			Protocols: &protocols,
+		},
+	}
+	s.client = s.newClient(false)
+	s.h2cClient = s.newClient(true)
+
+	go s.Config.Serve(l)
+	return s
+}
+
+func (s *HTTPServer) newClient(h2c bool) *http.Client {
+	var protocols http.Protocols
+	if h2c {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP1(true)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: s.Listener.DialContext,
+			Protocols:   &protocols,
+		},
+	}
+}
+
+// Client returns an HTTP/1.1 client, which connects to the server.
+// Requests to any address are sent to the server.
+func (s *HTTPServer) Client() *http.Client {
+	return s.client
+}
+
+// H2CClient returns an HTTP/2 client, which connects to the server
+// without TLS, using prior knowledge of the server's HTTP/2 support.
+// Requests to any address are sent to the server.
+func (s *HTTPServer) H2CClient() *http.Client {
+	return s.h2cClient
+}
+
+// Close shuts down the server and closes all its connections.
+func (s *HTTPServer) Close() {
+	s.Config.Close()
+	s.client.CloseIdleConnections()
+	s.h2cClient.CloseIdleConnections()
+	s.Listener.CloseAll()
+}
@@ -0,0 +1,56 @@
+package testlistener
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPServer(t *testing.T) {
+	srv := NewHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto+" "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		want   string
+	}{
+		{"http1", srv.Client(), "HTTP/1.1 /foo"},
+		{"h2c", srv.H2CClient(), "HTTP/2.0 /foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := tt.client.Get(srv.URL + "/foo")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Read body failed: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("got body %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_Close(t *testing.T) {
+	srv := NewHTTPServer(http.NotFoundHandler())
+	TrackLeaks(t, srv.Listener)
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// The client keeps the connection alive, until the server is closed
+	srv.Close()
+}
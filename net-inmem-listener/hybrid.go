@@ -0,0 +1,100 @@
+package testlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	_ net.Listener = (*HybridListener)(nil)
+	_ Dialer       = (*HybridListener)(nil)
+)
+
+// HybridListener accepts both the connections of a real net.Listener and
+// the in-memory connections, created by Connect, through a single Accept.
+// A server under test can listen on a real port for manual debugging, while
+// tests connect to it in-process.
+//
+// The methods of the embedded Listener, such as Connect or Stats, deal with
+// the in-memory connections only.
+type HybridListener struct {
+	*Listener
+	real net.Listener
+
+	accepted  chan acceptResult
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// NewHybridListener creates a listener, which accepts the connections of
+// the real listener along with in-memory ones. The in-memory connections
+// report the real listener's address as the server's address.
+// The options apply to the in-memory connections.
+func NewHybridListener(real net.Listener, opts ...Option) *HybridListener {
+	opts = append([]Option{WithAddr(real.Addr())}, opts...)
+	h := &HybridListener{
+		Listener: NewListener(opts...),
+		real:     real,
+		accepted: make(chan acceptResult),
+	}
+	go h.acceptReal()
+	return h
+}
+
+// acceptReal forwards the connections and errors of the real listener
+// to Accept until either listener is closed. Other errors, such as
+// the temporary ones, which http.Server retries, don't stop it.
+func (h *HybridListener) acceptReal() {
+	for {
+		conn, err := h.real.Accept()
+		if err != nil && h.closed() {
+			return
+		}
+		select {
+		case h.accepted <- acceptResult{conn, err}:
+		case <-h.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next connection, either real or in-memory.
+func (h *HybridListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-h.conns:
+		conn.pair.accept()
+		return conn, nil
+	case r := <-h.accepted:
+		return r.conn, r.err
+	case <-h.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops both the real and in-memory listeners, after which Accept
+// returns net.ErrClosed. It returns the error of closing the real listener.
+// It's safe to call Close more than once.
+func (h *HybridListener) Close() error {
+	h.closeOnce.Do(func() {
+		h.Listener.Close()
+		h.closeErr = h.real.Close()
+	})
+	return h.closeErr
+}
+
+// Addr returns the real listener's address.
+func (h *HybridListener) Addr() net.Addr {
+	return h.real.Addr()
+}
@@ -0,0 +1,136 @@
+package testlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHybridListener_HTTP(t *testing.T) {
+	real, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	h := NewHybridListener(real)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from "+r.RemoteAddr)
+		}),
+	}
+	go srv.Serve(h)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		want   string
+	}{
+		{"real", &http.Client{Transport: &http.Transport{}}, "hello from 127.0.0.1:"},
+		{"in-memory", &http.Client{Transport: &http.Transport{DialContext: h.DialContext}}, "hello from pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get("http://" + h.Addr().String() + "/")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Read body failed: %v", err)
+			}
+			if !strings.HasPrefix(string(body), tt.want) {
+				t.Errorf("got body %q, want prefix %q", body, tt.want)
+			}
+		})
+	}
+	if got := h.Stats().Accepted; got != 1 {
+		t.Errorf("Stats().Accepted = %d, want 1", got)
+	}
+}
+
+func TestHybridListener_Close(t *testing.T) {
+	real, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	h := NewHybridListener(real)
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := h.Accept()
+		acceptErr <- err
+	}()
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-acceptErr; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("blocked Accept got error %v, want %v", err, net.ErrClosed)
+	}
+	if _, err := h.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close got error %v, want %v", err, net.ErrClosed)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+
+	// Both sources are shut down
+	if _, err := h.Connect(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Connect got error %v, want %v", err, net.ErrClosed)
+	}
+	if conn, err := net.Dial("tcp", h.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("Dial real listener succeeded after Close")
+	}
+}
+
+func TestHybridListener_TemporaryError(t *testing.T) {
+	real, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	h := NewHybridListener(&flakyListener{Listener: real, fails: 1})
+	t.Cleanup(func() {
+		h.Close()
+	})
+
+	if _, err := h.Accept(); !errors.Is(err, errTemporary) {
+		t.Fatalf("Accept got error %v, want %v", err, errTemporary)
+	}
+
+	// The real connections are still accepted after the error
+	client, err := net.Dial("tcp", h.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	conn, err := h.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	conn.Close()
+}
+
+var errTemporary = errors.New("temporary accept error")
+
+// flakyListener fails the first Accept calls with errTemporary.
+type flakyListener struct {
+	net.Listener
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, errTemporary
+	}
+	return l.Listener.Accept()
+}
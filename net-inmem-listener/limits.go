@@ -0,0 +1,48 @@
+package testlistener
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// LimitError is returned by Connect when the listener can't take more connections.
+// It unwraps to syscall.ECONNREFUSED.
+type LimitError struct {
+	// Backlog is true if the listener's Accept backlog is full,
+	// otherwise the listener reached its maximum of live connections.
+	Backlog bool
+	// Limit is the configured size of the exhausted limit.
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	if e.Backlog {
+		return fmt.Sprintf("accept backlog of %d connections is full", e.Limit)
+	}
+	return fmt.Sprintf("limit of %d live connections is reached", e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return syscall.ECONNREFUSED
+}
+
+// WithBacklog makes the listener queue up to n connections, which weren't
+// accepted yet. Connect doesn't wait for Accept, unless the queue is full,
+// in which case it fails with a LimitError.
+// By default, the listener has no backlog and Connect blocks until Accept.
+// It has no effect when passed to Connect.
+func WithBacklog(n int) Option {
+	return func(cfg *config) {
+		cfg.backlog = n
+	}
+}
+
+// WithMaxConns limits the number of live connections of the listener.
+// A connection is live until both of its ends are closed. Once the limit
+// is reached, Connect fails with a LimitError.
+// It has no effect when passed to Connect.
+func WithMaxConns(n int) Option {
+	return func(cfg *config) {
+		cfg.maxConns = n
+	}
+}
@@ -0,0 +1,109 @@
+package testlistener
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestListener_Backlog(t *testing.T) {
+	l := NewListener(WithBacklog(2))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	// Nobody accepts, so the connections wait in the backlog
+	for range 2 {
+		conn, err := l.Connect()
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		t.Cleanup(func() {
+			conn.Close()
+		})
+	}
+
+	_, err := l.Connect()
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !limitErr.Backlog {
+		t.Fatalf("Connect got error %v, want backlog %T", err, limitErr)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Connect got error %v, want %v", err, syscall.ECONNREFUSED)
+	}
+
+	// Accept frees up a place in the backlog
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+	})
+	conn, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect after Accept failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListener_MaxConns(t *testing.T) {
+	l := NewListener(WithBacklog(10), WithMaxConns(1))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	_, err = l.Connect()
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Backlog {
+		t.Fatalf("Connect got error %v, want max conns %T", err, limitErr)
+	}
+	if limitErr.Limit != 1 {
+		t.Errorf("LimitError.Limit = %d, want %d", limitErr.Limit, 1)
+	}
+
+	// The connection stays live until both ends are closed
+	client.Close()
+	if _, err := l.Connect(); !errors.As(err, &limitErr) {
+		t.Errorf("Connect with half-closed conn got error %v, want %T", err, limitErr)
+	}
+	server.Close()
+
+	conn, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect after close failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListener_BacklogClose(t *testing.T) {
+	for range 100 {
+		l := NewListener(WithBacklog(4))
+
+		// The listener is closed after the conns are created,
+		// but before the server end is put into the backlog
+		closeOnPipe := func(cfg *config) {
+			cfg.onPipe = func(client, server *conn) {
+				l.Close()
+			}
+		}
+		conn, err := l.Connect(closeOnPipe)
+		if !errors.Is(err, net.ErrClosed) {
+			conn.Close()
+			t.Fatalf("Connect got error %v, want %v", err, net.ErrClosed)
+		}
+		if got := l.ActiveConns(); got != 0 {
+			t.Fatalf("ActiveConns() after Close = %d, want %d", got, 0)
+		}
+	}
+}
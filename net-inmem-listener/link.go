@@ -0,0 +1,62 @@
+package testlistener
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// maxSegmentSize limits how much data of a single Write travels through
+// a connection as one piece, so a reader sees large writes arrive gradually.
+const maxSegmentSize = 1460
+
+// Link describes conditions of the network link between the ends of a connection.
+// The conditions apply to each direction of the connection independently.
+type Link struct {
+	// Latency is the one-way delay of the data.
+	Latency time.Duration
+	// Jitter is the upper bound of a random delay added to Latency.
+	// The data is never reordered, regardless of Jitter.
+	Jitter time.Duration
+	// Bandwidth is the link's capacity in bytes per second.
+	// Zero means unlimited bandwidth.
+	Bandwidth int
+}
+
+// WithLink makes connections simulate the given link conditions.
+// The link's timing is based on the time package's clock, so inside
+// a testing/synctest bubble the delays pass in virtual time.
+// If the buffer size wasn't set with WithBuffer, 64KiB buffers are used.
+func WithLink(link Link) Option {
+	return func(cfg *config) {
+		cfg.link = &link
+	}
+}
+
+// linkState tracks the timing of data sent through a Link.
+type linkState struct {
+	Link
+	idleAt time.Time // when the link finishes transmitting all data sent so far
+	lastAt time.Time // when the last sent data arrives at the reader
+}
+
+// schedule returns the arrival time of n bytes sent through the link at now.
+func (l *linkState) schedule(n int, now time.Time) time.Time {
+	start := now
+	if l.idleAt.After(start) {
+		start = l.idleAt
+	}
+	l.idleAt = start
+	if l.Bandwidth > 0 {
+		l.idleAt = start.Add(time.Duration(n) * time.Second / time.Duration(l.Bandwidth))
+	}
+
+	at := l.idleAt.Add(l.Latency)
+	if l.Jitter > 0 {
+		at = at.Add(rand.N(l.Jitter))
+	}
+	if at.Before(l.lastAt) {
+		at = l.lastAt
+	}
+	l.lastAt = at
+	return at
+}
@@ -0,0 +1,106 @@
+//go:build simtest
+
+package testlistener
+
+import (
+	"io"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestLink_Latency(t *testing.T) {
+	synctest.Run(func() {
+		l := NewListener(WithLink(Link{Latency: 50 * time.Millisecond}))
+		defer l.Close()
+
+		client, server := connectPair(t, l)
+		defer client.Close()
+		defer server.Close()
+
+		start := time.Now()
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if d := time.Since(start); d != 0 {
+			t.Errorf("Write took %v, want no delay", d)
+		}
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if d, want := time.Since(start), 50*time.Millisecond; d != want {
+			t.Errorf("Read took %v, want %v", d, want)
+		}
+
+		// The EOF is delayed as well
+		client.Close()
+		start = time.Now()
+		if _, err := server.Read(buf); err != io.EOF {
+			t.Fatalf("Read got error %v, want %v", err, io.EOF)
+		}
+		if d, want := time.Since(start), 50*time.Millisecond; d != want {
+			t.Errorf("Read EOF took %v, want %v", d, want)
+		}
+	})
+}
+
+func TestLink_Bandwidth(t *testing.T) {
+	synctest.Run(func() {
+		l := NewListener()
+		defer l.Close()
+
+		// The link passes 10KiB per second, so 20KiB take two seconds
+		client, server := connectPair(t, l, WithLink(Link{Bandwidth: 10 << 10}))
+		defer client.Close()
+		defer server.Close()
+
+		start := time.Now()
+		go func() {
+			client.Write(make([]byte, 20<<10))
+		}()
+
+		if _, err := io.ReadFull(server, make([]byte, 20<<10)); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if d, want := time.Since(start), 2*time.Second; d != want {
+			t.Errorf("Read took %v, want %v", d, want)
+		}
+	})
+}
+
+func TestLink_Jitter(t *testing.T) {
+	synctest.Run(func() {
+		link := Link{
+			Latency: 10 * time.Millisecond,
+			Jitter:  10 * time.Millisecond,
+		}
+		l := NewListener(WithLink(link))
+		defer l.Close()
+
+		client, server := connectPair(t, l)
+		defer client.Close()
+		defer server.Close()
+
+		start := time.Now()
+		go func() {
+			for i := range 100 {
+				client.Write([]byte{byte(i)})
+			}
+		}()
+
+		buf := make([]byte, 100)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		for i, b := range buf {
+			if int(b) != i {
+				t.Fatalf("Read byte %d at position %d, the data is reordered", b, i)
+			}
+		}
+		if d := time.Since(start); d < link.Latency || d >= link.Latency+link.Jitter {
+			t.Errorf("Read took %v, want between %v and %v", d, link.Latency, link.Latency+link.Jitter)
+		}
+	})
+}
@@ -1,12 +1,94 @@
 package testlistener
 
-import "net"
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Dialer is the interface implemented by anything that can dial a connection,
+// such as Listener or net.Dialer. Its method matches http.Transport.DialContext.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var (
+	_ net.Listener = (*Listener)(nil)
+	_ Dialer       = (*Listener)(nil)
+	_ Dialer       = (*net.Dialer)(nil)
+)
 
 // Listener implements net.Listener.
 type Listener struct {
-	addr  addr
-	conns chan net.Conn
+	addr  net.Addr
+	conns chan *trackedConn
 	done  chan struct{}
+	cfg   config
+	seq   atomic.Int64 // sequence number of the last connection
+
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	pairs   map[*connPair]struct{} // connections with an open end
+	history []*connPair            // all connections, for stats; never shrinks
+	changed chan struct{}          // closed when a connection is closed
+}
+
+// Option configures a Listener or a single connection created by Connect.
+type Option func(*config)
+
+type config struct {
+	bufSize    int
+	link       *Link
+	faultRules []FaultRule
+	addr       net.Addr
+	remoteAddr net.Addr
+	backlog    int
+	maxConns   int
+	capture    *Capture
+	wrappers   []func(client, server net.Conn) (net.Conn, net.Conn)
+	onConnect  func(net.Conn)
+	onAccept   func(net.Conn)
+	onClose    func(ConnStats)
+	onPipe     func(client, server *conn)
+	// proxyHeader is sent by the client end, before Connect returns.
+	proxyHeader []byte
+}
+
+// defaultBufSize is the connection's buffer size used by features, which
+// need asynchronous connections, when the size isn't set with WithBuffer.
+const defaultBufSize = 64 << 10
+
+// WithBuffer makes the listener create asynchronous connections, where each
+// direction of a connection has a receive buffer of the given size.
+// A Write completes without a waiting reader, as long as its data fits into
+// the buffer, the way it does with a TCP socket.
+// A size of zero keeps the default synchronous connections, where a Write
+// blocks until the peer reads all of its data, as with net.Pipe.
+func WithBuffer(size int) Option {
+	return func(cfg *config) {
+		cfg.bufSize = size
+	}
+}
+
+// WithAddr sets the listener's address, which is also the local address
+// of the server ends and the remote address of the client ends of connections.
+// It has no effect when passed to Connect.
+func WithAddr(addr net.Addr) Option {
+	return func(cfg *config) {
+		cfg.addr = addr
+	}
+}
+
+// WithRemoteAddr sets the address of the client end of a connection.
+// The server end reports it as its RemoteAddr.
+func WithRemoteAddr(addr net.Addr) Option {
+	return func(cfg *config) {
+		cfg.remoteAddr = addr
+	}
 }
 
 // addr implements net.Addr.
@@ -16,34 +98,72 @@ func (a addr) Network() string { return "addr" }
 func (a addr) String() string  { return "addr" }
 
 // NewListener creates a new in-memory listener.
-func NewListener() *Listener {
-	return &Listener{
-		addr:  addr{},
-		conns: make(chan net.Conn),
-		done:  make(chan struct{}),
+func NewListener(opts ...Option) *Listener {
+	l := &Listener{
+		addr:    addr{},
+		done:    make(chan struct{}),
+		pairs:   make(map[*connPair]struct{}),
+		changed: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(&l.cfg)
+	}
+	if l.cfg.addr != nil {
+		l.addr = l.cfg.addr
+	}
+	l.conns = make(chan *trackedConn, l.cfg.backlog)
+	return l
 }
 
 func (l *Listener) Accept() (net.Conn, error) {
 	select {
 	case conn := <-l.conns:
+		conn.pair.accept()
 		return conn, nil
 	case <-l.done:
 		return nil, net.ErrClosed
 	}
 }
 
+// Close stops the listener. Connections, which were already accepted,
+// stay open. It's safe to call Close more than once.
 func (l *Listener) Close() error {
-	close(l.done)
-	return nil
+	// Closing under the lock makes sure no connection gets into the backlog
+	// after it was drained, see enqueue
+	l.mu.Lock()
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	l.mu.Unlock()
+
+	// Drop the connections, which are still in the backlog
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
 }
 
 func (l *Listener) Addr() net.Addr {
 	return l.addr
 }
 
+func (l *Listener) closed() bool {
+	return isClosedChan(l.done)
+}
+
 // Connect creates a new connection pair and sends one end to the listener.
-func (l *Listener) Connect() (net.Conn, error) {
+// The options passed to Connect override the listener's ones for this connection.
+func (l *Listener) Connect(opts ...Option) (net.Conn, error) {
+	return l.ConnectContext(context.Background(), opts...)
+}
+
+// ConnectContext is like Connect but aborts waiting for Accept when ctx is done.
+// In that case it returns ctx.Err().
+func (l *Listener) ConnectContext(ctx context.Context, opts ...Option) (net.Conn, error) {
 	// Check if listener is closed first
 	select {
 	case <-l.done:
@@ -51,13 +171,106 @@ func (l *Listener) Connect() (net.Conn, error) {
 	default:
 	}
 
-	client, server := net.Pipe()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cfg := l.cfg.clone()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	client, server := cfg.pipe()
+	if cfg.addr != nil || cfg.remoteAddr != nil {
+		client, server = withAddrs(client, server, cfg.remoteAddr, l.addr)
+	}
+	seq := int(l.seq.Add(1))
+	if cfg.capture != nil {
+		client, server = cfg.capture.wrap(client, server)
+	}
+	client, server = injectFaults(cfg.faultRules, seq, client, server)
+	for _, wrap := range cfg.wrappers {
+		client, server = wrap(client, server)
+	}
+
+	tclient, tserver, err := l.track(cfg, seq, client, server)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.proxyHeader) > 0 {
+		if _, err := tclient.Write(cfg.proxyHeader); err != nil {
+			tclient.Close()
+			tserver.Close()
+			return nil, fmt.Errorf("testlistener: write PROXY header: %w", err)
+		}
+	}
+	if err := l.enqueue(ctx, tserver); err != nil {
+		tclient.Close()
+		tserver.Close()
+		return nil, err
+	}
+
+	if cfg.onConnect != nil {
+		cfg.onConnect(tclient)
+	}
+	return tclient, nil
+}
+
+// enqueue sends the server end of a connection to Accept.
+func (l *Listener) enqueue(ctx context.Context, server *trackedConn) error {
+	if cap(l.conns) > 0 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.closed() {
+			return net.ErrClosed
+		}
+		select {
+		case l.conns <- server:
+			return nil
+		default:
+			return &LimitError{Backlog: true, Limit: cap(l.conns)}
+		}
+	}
+
 	select {
 	case l.conns <- server:
-		return client, nil
+		return nil
 	case <-l.done:
-		client.Close()
-		server.Close()
-		return nil, net.ErrClosed
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ConnectFrom is like Connect, but the server end of the connection reports
+// remote as its RemoteAddr, as if the connection came from that address.
+func (l *Listener) ConnectFrom(remote net.Addr, opts ...Option) (net.Conn, error) {
+	return l.Connect(append(opts[:len(opts):len(opts)], WithRemoteAddr(remote))...)
+}
+
+// DialContext connects to the listener. The network and address are ignored,
+// so the method can be used as http.Transport.DialContext for any URL.
+func (l *Listener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return l.ConnectContext(ctx)
+}
+
+// clone returns a copy of the config, which options can change without
+// affecting the original.
+func (cfg config) clone() config {
+	cfg.faultRules = slices.Clip(cfg.faultRules)
+	cfg.wrappers = slices.Clip(cfg.wrappers)
+	return cfg
+}
+
+// pipe creates a new connection pair according to the config.
+func (cfg config) pipe() (net.Conn, net.Conn) {
+	size := cfg.bufSize
+	if size == 0 && (cfg.link != nil || len(cfg.proxyHeader) > 0) {
+		size = defaultBufSize
+	}
+	client, server := pipeConns(size, cfg.link)
+	if cfg.onPipe != nil {
+		cfg.onPipe(client, server)
 	}
+	return client, server
 }
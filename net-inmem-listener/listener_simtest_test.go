@@ -3,6 +3,7 @@
 package testlistener
 
 import (
+	"context"
 	"errors"
 	"net"
 	"testing"
@@ -55,3 +56,27 @@ func TestListener_CloseDuringConnect(t *testing.T) {
 		}
 	})
 }
+
+func TestListener_CancelDuringConnect(t *testing.T) {
+	synctest.Run(func() {
+		l := NewListener()
+		defer l.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := l.ConnectContext(ctx)
+			errs <- err
+		}()
+
+		// Wait for the connect goroutine to block the bubble
+		synctest.Wait()
+
+		cancel()
+
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Errorf("blocked Connect got error %v, want %v", err, context.Canceled)
+		}
+	})
+}
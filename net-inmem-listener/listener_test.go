@@ -9,135 +9,212 @@ import (
 )
 
 func TestListener_AcceptConnect(t *testing.T) {
-	l := NewListener()
-	t.Cleanup(func() {
-		l.Close()
-	})
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		acceptErrs := make(chan error, 1)
+		var serverConn net.Conn
+		go func() {
+			var err error
+			serverConn, err = l.Accept()
+			acceptErrs <- err
+		}()
 
-	acceptErrs := make(chan error, 1)
-	var serverConn net.Conn
-	go func() {
-		var err error
-		serverConn, err = l.Accept()
-		acceptErrs <- err
-	}()
+		clientConn, err := l.Connect()
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
 
-	clientConn, err := l.Connect()
-	if err != nil {
-		t.Fatalf("Connect failed: %v", err)
-	}
+		// Wait until the accept goroutine completes
+		if err := <-acceptErrs; err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
 
-	// Wait until the accept goroutine completes
-	if err := <-acceptErrs; err != nil {
-		t.Fatalf("Accept failed: %v", err)
-	}
+		t.Cleanup(func() {
+			serverConn.Close()
+		})
 
-	t.Cleanup(func() {
-		serverConn.Close()
+		// Test data transfer
+		testData := []byte("hello")
+		go func() {
+			clientConn.Write(testData)
+			clientConn.Close()
+		}()
+
+		buf := make([]byte, len(testData))
+		n, err := io.ReadFull(serverConn, buf)
+		if err != nil {
+			t.Fatalf("Read from server connection failed: %v", err)
+		}
+		if n != len(testData) {
+			t.Errorf("Read %d bytes, want %d", n, len(testData))
+		}
+		if string(buf) != string(testData) {
+			t.Errorf("Read %q, want %q", buf, testData)
+		}
 	})
+}
 
-	// Test data transfer
-	testData := []byte("hello")
-	go func() {
-		clientConn.Write(testData)
-		clientConn.Close()
-	}()
+func TestListener_Close(t *testing.T) {
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+
+		if err := l.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+
+		// Verify that Accept returns net.ErrClosed after close
+		_, err := l.Accept()
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept after close got error %v, want %v", err, net.ErrClosed)
+		}
+
+		// Verify that Connect returns net.ErrClosed after close
+		_, err = l.Connect()
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Connect after close got error %v, want %v", err, net.ErrClosed)
+		}
+	})
+}
 
-	buf := make([]byte, len(testData))
-	n, err := io.ReadFull(serverConn, buf)
-	if err != nil {
-		t.Fatalf("Read from server connection failed: %v", err)
-	}
-	if n != len(testData) {
-		t.Errorf("Read %d bytes, want %d", n, len(testData))
-	}
-	if string(buf) != string(testData) {
-		t.Errorf("Read %q, want %q", buf, testData)
-	}
+func TestListener_multipleConcurrentConnections(t *testing.T) {
+	forEachMode(t, func(t *testing.T, opts ...Option) {
+		l := NewListener(opts...)
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		const numConns = 5
+		var wg sync.WaitGroup
+		wg.Add(numConns * 2) // For both client and server goroutines
+
+		// Start accepting connections
+		for range numConns {
+			go func() {
+				defer wg.Done()
+				conn, err := l.Accept()
+				if err != nil {
+					t.Errorf("Accept failed: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				// Read the connection index
+				buf := make([]byte, 1)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					t.Errorf("Read failed: %v", err)
+				}
+			}()
+		}
+
+		// Create multiple connections
+		for i := range numConns {
+			go func(idx int) {
+				defer wg.Done()
+				conn, err := l.Connect()
+				if err != nil {
+					t.Errorf("Connect failed: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				// Write the connection index
+				if _, err := conn.Write([]byte{byte(idx)}); err != nil {
+					t.Errorf("Write failed: %v", err)
+				}
+			}(i)
+		}
+
+		// Wait for all goroutines to complete
+		wg.Wait()
+	})
 }
 
-func TestListener_Close(t *testing.T) {
+func TestListener_Addr(t *testing.T) {
 	l := NewListener()
+	t.Cleanup(func() {
+		l.Close()
+	})
 
-	if err := l.Close(); err != nil {
-		t.Errorf("Close failed: %v", err)
-	}
-
-	// Verify that Accept returns net.ErrClosed after close
-	_, err := l.Accept()
-	if !errors.Is(err, net.ErrClosed) {
-		t.Errorf("Accept after close got error %v, want %v", err, net.ErrClosed)
+	addr := l.Addr()
+	if got := addr.Network(); got != "addr" {
+		t.Errorf("addr.Network() = %q, want \"addr\"", got)
 	}
-
-	// Verify that Connect returns net.ErrClosed after close
-	_, err = l.Connect()
-	if !errors.Is(err, net.ErrClosed) {
-		t.Errorf("Connect after close got error %v, want %v", err, net.ErrClosed)
+	if got := addr.String(); got != "addr" {
+		t.Errorf("addr.String() = %q, want \"addr\"", got)
 	}
 }
 
-func TestListener_multipleConcurrentConnections(t *testing.T) {
-	l := NewListener()
+func TestListener_ConnectFrom(t *testing.T) {
+	laddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}
+	l := NewListener(WithAddr(laddr))
 	t.Cleanup(func() {
 		l.Close()
 	})
 
-	const numConns = 5
-	var wg sync.WaitGroup
-	wg.Add(numConns * 2) // For both client and server goroutines
-
-	// Start accepting connections
-	for range numConns {
-		go func() {
-			defer wg.Done()
-			conn, err := l.Accept()
-			if err != nil {
-				t.Errorf("Accept failed: %v", err)
-				return
-			}
-			defer conn.Close()
-
-			// Read the connection index
-			buf := make([]byte, 1)
-			if _, err := io.ReadFull(conn, buf); err != nil {
-				t.Errorf("Read failed: %v", err)
-			}
-		}()
+	if got := l.Addr(); got != laddr {
+		t.Errorf("Addr() = %v, want %v", got, laddr)
 	}
 
-	// Create multiple connections
-	for i := range numConns {
-		go func(idx int) {
-			defer wg.Done()
-			conn, err := l.Connect()
-			if err != nil {
-				t.Errorf("Connect failed: %v", err)
-				return
-			}
-			defer conn.Close()
-
-			// Write the connection index
-			if _, err := conn.Write([]byte{byte(idx)}); err != nil {
-				t.Errorf("Write failed: %v", err)
-			}
-		}(i)
+	raddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 54321}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	client, err := l.ConnectFrom(raddr)
+	if err != nil {
+		t.Fatalf("ConnectFrom failed: %v", err)
 	}
+	server := <-accepted
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
 
-	// Wait for all goroutines to complete
-	wg.Wait()
+	if got := server.RemoteAddr(); got != raddr {
+		t.Errorf("server RemoteAddr() = %v, want %v", got, raddr)
+	}
+	if got := server.LocalAddr(); got != laddr {
+		t.Errorf("server LocalAddr() = %v, want %v", got, laddr)
+	}
+	if got := client.LocalAddr(); got != raddr {
+		t.Errorf("client LocalAddr() = %v, want %v", got, raddr)
+	}
+	if got := client.RemoteAddr(); got != laddr {
+		t.Errorf("client RemoteAddr() = %v, want %v", got, laddr)
+	}
 }
 
-func TestListener_Addr(t *testing.T) {
-	l := NewListener()
-	t.Cleanup(func() {
-		l.Close()
+// forEachMode runs the test against every kind of connections the listener can create.
+func forEachMode(t *testing.T, f func(t *testing.T, opts ...Option)) {
+	t.Run("sync", func(t *testing.T) {
+		f(t)
+	})
+	t.Run("buffered", func(t *testing.T) {
+		f(t, WithBuffer(1024))
 	})
+}
 
-	addr := l.Addr()
-	if got := addr.Network(); got != "addr" {
-		t.Errorf("addr.Network() = %q, want \"addr\"", got)
-	}
-	if got := addr.String(); got != "addr" {
-		t.Errorf("addr.String() = %q, want \"addr\"", got)
+// connectPair connects to the listener and returns both ends of the connection.
+func connectPair(t *testing.T, l *Listener, opts ...Option) (client, server net.Conn) {
+	t.Helper()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	client, err := l.Connect(opts...)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
 	}
+	return client, <-accepted
 }
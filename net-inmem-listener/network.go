@@ -0,0 +1,282 @@
+package testlistener
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+)
+
+var (
+	_ Dialer = (*Network)(nil)
+	_ Dialer = (*Endpoint)(nil)
+)
+
+// Network is a virtual in-memory network, where listeners are addressed by name.
+//
+// The hosts of the network can be partitioned from each other, see Partition.
+// A host is named by the host part of an address, so the listener "db:5432"
+// and the endpoint From("db") are the same host "db".
+type Network struct {
+	mu         sync.Mutex
+	listeners  map[string]*Listener
+	opts       []Option
+	partitions map[hostPair]PartitionMode
+	routes     map[*route]struct{} // connections dialed from named endpoints
+}
+
+// PartitionMode tells what happens to the existing connections between
+// hosts, when they are partitioned.
+type PartitionMode int
+
+const (
+	// PartitionStall stops the delivery of data over the connections until
+	// the partition heals, as if the network dropped all packets.
+	// Read blocks, and Write blocks once the peer's buffer is full.
+	// Deadlines keep working.
+	PartitionStall PartitionMode = iota
+	// PartitionReset resets the connections, so Read and Write on both ends
+	// fail with syscall.ECONNRESET. The connections stay broken after
+	// the partition heals.
+	PartitionReset
+)
+
+// hostPair is an unordered pair of hosts.
+type hostPair [2]string
+
+func makeHostPair(a, b string) hostPair {
+	a, b = hostOf(a), hostOf(b)
+	if a > b {
+		a, b = b, a
+	}
+	return hostPair{a, b}
+}
+
+// hostOf returns the host part of the address, if it has a port.
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// route is a connection between two hosts of a network.
+type route struct {
+	hosts          hostPair
+	client, server *conn
+}
+
+func (r *route) closed() bool {
+	return r.client.closed.Load() && r.server.closed.Load()
+}
+
+// apply partitions the connection's hosts with the given mode.
+func (r *route) apply(mode PartitionMode) {
+	switch mode {
+	case PartitionStall:
+		r.client.stall(true)
+	case PartitionReset:
+		r.client.reset()
+	}
+}
+
+// memAddr implements net.Addr for the endpoints of a Network.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// NewNetwork creates a new in-memory network.
+// The options apply to every listener of the network.
+func NewNetwork(opts ...Option) *Network {
+	return &Network{
+		listeners:  make(map[string]*Listener),
+		opts:       opts,
+		partitions: make(map[hostPair]PartitionMode),
+		routes:     make(map[*route]struct{}),
+	}
+}
+
+// Listen creates a new listener with the given address on the network.
+// It fails if the address is already used by a listener, which isn't closed.
+func (n *Network) Listen(address string, opts ...Option) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if l, ok := n.listeners[address]; ok && !l.closed() {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  "mem",
+			Addr: memAddr(address),
+			Err:  syscall.EADDRINUSE,
+		}
+	}
+
+	opts = append(n.opts[:len(n.opts):len(n.opts)], opts...)
+	l := NewListener(append(opts, WithAddr(memAddr(address)))...)
+	n.listeners[address] = l
+	return l, nil
+}
+
+// Dial connects to the listener with the given address on the network.
+func (n *Network) Dial(address string) (net.Conn, error) {
+	return n.DialContext(context.Background(), "mem", address)
+}
+
+// DialContext connects to the listener with the given address on the network.
+// If there is no such listener, it fails with syscall.ECONNREFUSED.
+// The network argument only appears in errors, so the method can be used as
+// http.Transport.DialContext.
+//
+// The connections dialed by the network don't come from any host, so
+// partitions don't affect them. Use From to dial from a named host.
+func (n *Network) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return n.dial(ctx, "", network, address)
+}
+
+// From returns the endpoint, which dials the listeners of the network
+// on behalf of the named host. The server ends of its connections report
+// the name as their RemoteAddr.
+func (n *Network) From(name string) *Endpoint {
+	return &Endpoint{network: n, name: name}
+}
+
+func (n *Network) dial(ctx context.Context, from, network, address string) (net.Conn, error) {
+	hosts := makeHostPair(from, address)
+
+	n.mu.Lock()
+	l := n.listeners[address]
+	_, partitioned := n.partitions[hosts]
+	n.mu.Unlock()
+
+	if l == nil {
+		return nil, refusedErr(network, address)
+	}
+	if from != "" && partitioned {
+		return nil, unreachableErr(network, address)
+	}
+
+	var opts []Option
+	if from != "" {
+		opts = append(opts, WithRemoteAddr(memAddr(from)), func(cfg *config) {
+			cfg.onPipe = func(client, server *conn) {
+				n.addRoute(&route{hosts: hosts, client: client, server: server})
+			}
+		})
+	}
+	conn, err := l.ConnectContext(ctx, opts...)
+	if err == net.ErrClosed {
+		return nil, refusedErr(network, address)
+	}
+	return conn, err
+}
+
+// addRoute tracks the connection, applying the partition between its hosts
+// if they were partitioned while the connection was dialed.
+func (n *Network) addRoute(r *route) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if mode, ok := n.partitions[r.hosts]; ok {
+		r.apply(mode)
+	}
+	n.routes[r] = struct{}{}
+}
+
+// Partition partitions the hosts a and b from each other. New dials between
+// them fail with syscall.EHOSTUNREACH, and the existing connections stall or
+// reset according to the mode. Other hosts can still reach both a and b.
+func (n *Network) Partition(a, b string, mode PartitionMode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hosts := makeHostPair(a, b)
+	n.partitions[hosts] = mode
+	for r := range n.routes {
+		if r.closed() {
+			delete(n.routes, r)
+		} else if r.hosts == hosts {
+			r.apply(mode)
+		}
+	}
+}
+
+// Heal removes the partition between the hosts a and b. The stalled
+// connections between them resume delivering data.
+func (n *Network) Heal(a, b string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hosts := makeHostPair(a, b)
+	delete(n.partitions, hosts)
+	for r := range n.routes {
+		if r.closed() {
+			delete(n.routes, r)
+		} else if r.hosts == hosts {
+			r.client.stall(false)
+		}
+	}
+}
+
+// HealAll removes all partitions of the network.
+func (n *Network) HealAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	clear(n.partitions)
+	for r := range n.routes {
+		if r.closed() {
+			delete(n.routes, r)
+		} else {
+			r.client.stall(false)
+		}
+	}
+}
+
+// Close closes all listeners of the network.
+func (n *Network) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for address, l := range n.listeners {
+		l.Close()
+		delete(n.listeners, address)
+	}
+	return nil
+}
+
+// Endpoint dials the listeners of a Network on behalf of a named host.
+type Endpoint struct {
+	network *Network
+	name    string
+}
+
+// Dial connects to the listener with the given address on the network.
+func (e *Endpoint) Dial(address string) (net.Conn, error) {
+	return e.DialContext(context.Background(), "mem", address)
+}
+
+// DialContext is like Network.DialContext, but the connection comes from
+// the endpoint's host. It fails with syscall.EHOSTUNREACH, if the hosts are
+// partitioned.
+func (e *Endpoint) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return e.network.dial(ctx, e.name, network, address)
+}
+
+func refusedErr(network, address string) error {
+	return &net.OpError{
+		Op:   "dial",
+		Net:  network,
+		Addr: memAddr(address),
+		Err:  syscall.ECONNREFUSED,
+	}
+}
+
+func unreachableErr(network, address string) error {
+	return &net.OpError{
+		Op:   "dial",
+		Net:  network,
+		Addr: memAddr(address),
+		Err:  syscall.EHOSTUNREACH,
+	}
+}
@@ -0,0 +1,279 @@
+package testlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Dial(t *testing.T) {
+	n := NewNetwork()
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	for _, name := range []string{"api:8080", "db:5432"} {
+		l, err := n.Listen(name)
+		if err != nil {
+			t.Fatalf("Listen(%q) failed: %v", name, err)
+		}
+		if got := l.Addr().String(); got != name {
+			t.Errorf("Addr() = %q, want %q", got, name)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, name)
+				conn.Close()
+			}
+		}()
+	}
+
+	for _, name := range []string{"api:8080", "db:5432"} {
+		conn, err := n.Dial(name)
+		if err != nil {
+			t.Fatalf("Dial(%q) failed: %v", name, err)
+		}
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(got) != name {
+			t.Errorf("Dial(%q) connected to %q", name, got)
+		}
+		conn.Close()
+	}
+}
+
+func TestNetwork_DialUnknown(t *testing.T) {
+	n := NewNetwork()
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	_, err := n.Dial("nowhere:80")
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Dial got error %v, want %v", err, syscall.ECONNREFUSED)
+	}
+
+	// The same goes for a closed listener
+	l, err := n.Listen("api:8080")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	l.Close()
+	if _, err := n.Dial("api:8080"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Dial closed listener got error %v, want %v", err, syscall.ECONNREFUSED)
+	}
+}
+
+func TestNetwork_ListenInUse(t *testing.T) {
+	n := NewNetwork()
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	l, err := n.Listen("api:8080")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if _, err := n.Listen("api:8080"); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Listen got error %v, want %v", err, syscall.EADDRINUSE)
+	}
+
+	// The address is free again, once the listener is closed
+	l.Close()
+	if _, err := n.Listen("api:8080"); err != nil {
+		t.Errorf("Listen after close failed: %v", err)
+	}
+}
+
+func TestNetwork_HTTP(t *testing.T) {
+	n := NewNetwork()
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	l, err := n.Listen("api:8080")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from "+r.Host)
+		}),
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: n.DialContext,
+		},
+	}
+	resp, err := client.Get("http://api:8080/")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if want := "hello from api:8080"; string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+
+	var opErr *net.OpError
+	if _, err := client.Get("http://web:80/"); !errors.As(err, &opErr) {
+		t.Errorf("Get unknown host got error %v, want %T", err, opErr)
+	}
+}
+
+func TestNetwork_Partition(t *testing.T) {
+	n := NewNetwork(WithBuffer(1024))
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	l, err := n.Listen("db:5432")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	api := n.From("api")
+	n.Partition("api", "db:5432", PartitionStall)
+	if _, err := api.Dial("db:5432"); !errors.Is(err, syscall.EHOSTUNREACH) {
+		t.Errorf("Dial partitioned got error %v, want %v", err, syscall.EHOSTUNREACH)
+	}
+	// Other hosts can still reach db
+	conn, err := n.From("worker").Dial("db:5432")
+	if err != nil {
+		t.Fatalf("Dial from other host failed: %v", err)
+	}
+	conn.Close()
+
+	n.Heal("api", "db")
+	conn, err = api.Dial("db:5432")
+	if err != nil {
+		t.Fatalf("Dial after heal failed: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.LocalAddr().String(); got != "api" {
+		t.Errorf("LocalAddr() = %q, want %q", got, "api")
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Read got %q, %v, want %q", buf, err, "ping")
+	}
+}
+
+func TestNetwork_PartitionStall(t *testing.T) {
+	n := NewNetwork(WithBuffer(1024), WithBacklog(1))
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	l, err := n.Listen("db:5432")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, err := n.From("api").Dial("db:5432")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	n.Partition("api", "db", PartitionStall)
+	if _, err := io.WriteString(client, "ping"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 4)
+	if _, err := server.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read during partition got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// The data written during the partition arrives once it heals
+	n.HealAll()
+	server.SetReadDeadline(time.Time{})
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Read after heal got %q, %v, want %q", buf, err, "ping")
+	}
+}
+
+func TestNetwork_PartitionReset(t *testing.T) {
+	n := NewNetwork(WithBacklog(1))
+	t.Cleanup(func() {
+		n.Close()
+	})
+
+	l, err := n.Listen("db:5432")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, err := n.From("api").Dial("db:5432")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 4))
+		readErr <- err
+	}()
+
+	n.Partition("db", "api", PartitionReset)
+	if err := <-readErr; !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("blocked Read got error %v, want %v", err, syscall.ECONNRESET)
+	}
+	if _, err := io.WriteString(client, "ping"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Write got error %v, want %v", err, syscall.ECONNRESET)
+	}
+
+	// The connection stays broken after the partition heals
+	n.Heal("db", "api")
+	if _, err := io.WriteString(client, "ping"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Write after heal got error %v, want %v", err, syscall.ECONNRESET)
+	}
+}
@@ -0,0 +1,241 @@
+package testlistener
+
+import (
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// packetQueueLen is the number of datagrams a PacketConn holds until they're
+// read. Datagrams, which don't fit in the queue, are dropped.
+const packetQueueLen = 256
+
+// PacketNetwork is an in-memory datagram network, where packet conns are
+// addressed by name. Like with UDP, the delivery of datagrams isn't reliable:
+// datagrams to unknown addresses or to a full queue are silently dropped.
+type PacketNetwork struct {
+	mu    sync.Mutex
+	conns map[string]*PacketConn
+	cfg   packetConfig
+}
+
+// PacketOption configures a PacketNetwork.
+type PacketOption func(*packetConfig)
+
+type packetConfig struct {
+	loss      float64
+	duplicate float64
+	reorder   float64
+	rand      *rand.Rand
+}
+
+// WithPacketLoss makes the network drop datagrams with the given probability.
+func WithPacketLoss(rate float64) PacketOption {
+	return func(cfg *packetConfig) {
+		cfg.loss = rate
+	}
+}
+
+// WithPacketDuplication makes the network deliver datagrams twice with
+// the given probability.
+func WithPacketDuplication(rate float64) PacketOption {
+	return func(cfg *packetConfig) {
+		cfg.duplicate = rate
+	}
+}
+
+// WithPacketReordering makes the network hold datagrams back with the given
+// probability. A held datagram is delivered right after the next datagram
+// to the same address.
+func WithPacketReordering(rate float64) PacketOption {
+	return func(cfg *packetConfig) {
+		cfg.reorder = rate
+	}
+}
+
+// WithPacketSeed makes the network's random faults reproducible.
+func WithPacketSeed(seed uint64) PacketOption {
+	return func(cfg *packetConfig) {
+		cfg.rand = rand.New(rand.NewPCG(seed, seed))
+	}
+}
+
+// NewPacketNetwork creates a new in-memory datagram network.
+func NewPacketNetwork(opts ...PacketOption) *PacketNetwork {
+	n := &PacketNetwork{
+		conns: make(map[string]*PacketConn),
+	}
+	for _, opt := range opts {
+		opt(&n.cfg)
+	}
+	if n.cfg.rand == nil {
+		n.cfg.rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return n
+}
+
+// ListenPacket creates a new packet conn with the given address on the network.
+// It fails if the address is already used by a packet conn, which isn't closed.
+func (n *PacketNetwork) ListenPacket(address string) (*PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.conns[address]; ok {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  "mem",
+			Addr: memAddr(address),
+			Err:  syscall.EADDRINUSE,
+		}
+	}
+
+	c := &PacketConn{
+		network:   n,
+		addr:      memAddr(address),
+		queue:     make(chan datagram, packetQueueLen),
+		done:      make(chan struct{}),
+		rdeadline: makeDeadline(),
+		wdeadline: makeDeadline(),
+	}
+	n.conns[address] = c
+	return c, nil
+}
+
+// send delivers the datagram to the packet conn with the given address.
+func (n *PacketNetwork) send(to string, d datagram) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	c := n.conns[to]
+	if c == nil || n.chance(n.cfg.loss) {
+		return
+	}
+
+	if c.held == nil && n.chance(n.cfg.reorder) {
+		c.held = &d
+		return
+	}
+	c.deliver(d)
+	if n.chance(n.cfg.duplicate) {
+		c.deliver(d)
+	}
+	if c.held != nil {
+		c.deliver(*c.held)
+		c.held = nil
+	}
+}
+
+// chance reports whether an event with the given probability happens.
+// The caller must hold n.mu.
+func (n *PacketNetwork) chance(p float64) bool {
+	return p > 0 && n.cfg.rand.Float64() < p
+}
+
+func (n *PacketNetwork) remove(c *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns[c.addr.String()] == c {
+		delete(n.conns, c.addr.String())
+	}
+}
+
+// datagram is a single message sent through the network.
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// PacketConn implements net.PacketConn.
+type PacketConn struct {
+	network *PacketNetwork
+	addr    memAddr
+	queue   chan datagram
+	held    *datagram // guarded by network.mu
+
+	closeOnce sync.Once
+	done      chan struct{}
+	rdeadline deadline
+	wdeadline deadline
+}
+
+// deliver puts the datagram to the conn's queue, dropping it if the queue is full.
+func (c *PacketConn) deliver(d datagram) {
+	select {
+	case c.queue <- d:
+	default:
+	}
+}
+
+// ReadFrom reads a datagram into b. If b is too small for the datagram,
+// the rest of it is discarded.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.rdeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+
+	select {
+	case d := <-c.queue:
+		return copy(b, d.data), d.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.rdeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends a datagram with the data from b to addr.
+// Like with UDP, it doesn't fail if nobody listens on addr.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.wdeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	c.network.send(addr.String(), datagram{
+		data: append([]byte(nil), b...),
+		from: c.addr,
+	})
+	return len(b), nil
+}
+
+// Close closes the conn and frees its address on the network.
+// It's safe to call Close more than once.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.network.remove(c)
+	})
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	c.wdeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline.set(t)
+	return nil
+}
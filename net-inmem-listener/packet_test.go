@@ -0,0 +1,139 @@
+package testlistener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPacketConn_ReadWrite(t *testing.T) {
+	n := NewPacketNetwork()
+	a := listenPacket(t, n, "a:53")
+	b := listenPacket(t, n, "b:53")
+
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	buf := make([]byte, 3)
+	got, from := readPacket(t, b, buf)
+	if got != "hel" {
+		t.Errorf("ReadFrom read %q, want the truncated datagram %q", got, "hel")
+	}
+	if from.String() != "a:53" {
+		t.Errorf("ReadFrom got address %v, want %v", from, "a:53")
+	}
+
+	// Datagrams to unknown addresses are dropped
+	if _, err := a.WriteTo([]byte("x"), memAddr("nowhere:53")); err != nil {
+		t.Errorf("WriteTo unknown address failed: %v", err)
+	}
+}
+
+func TestPacketConn_Close(t *testing.T) {
+	n := NewPacketNetwork()
+	c, err := n.ListenPacket("a:53")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	if _, err := n.ListenPacket("a:53"); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("ListenPacket got error %v, want %v", err, syscall.EADDRINUSE)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 1))
+		errs <- err
+	}()
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("blocked ReadFrom got error %v, want %v", err, net.ErrClosed)
+	}
+	if _, err := c.WriteTo([]byte("x"), c.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteTo after close got error %v, want %v", err, net.ErrClosed)
+	}
+
+	// The address is free again
+	listenPacket(t, n, "a:53")
+}
+
+func TestPacketConn_ReadDeadline(t *testing.T) {
+	c := listenPacket(t, NewPacketNetwork(), "a:53")
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, err := c.ReadFrom(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrom got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestPacketNetwork_Faults(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  PacketOption
+		want []string
+	}{
+		{"loss", WithPacketLoss(1), nil},
+		{"duplication", WithPacketDuplication(1), []string{"1", "1", "2", "2", "3", "3", "4", "4"}},
+		{"reordering", WithPacketReordering(1), []string{"2", "1", "4", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewPacketNetwork(tt.opt, WithPacketSeed(1))
+			a := listenPacket(t, n, "a:53")
+			b := listenPacket(t, n, "b:53")
+
+			for _, msg := range []string{"1", "2", "3", "4"} {
+				a.WriteTo([]byte(msg), b.LocalAddr())
+			}
+
+			var got []string
+			b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			for {
+				buf := make([]byte, 8)
+				n, _, err := b.ReadFrom(buf)
+				if err != nil {
+					break
+				}
+				got = append(got, string(buf[:n]))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got datagrams %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got datagrams %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func listenPacket(t *testing.T, n *PacketNetwork, address string) *PacketConn {
+	t.Helper()
+	c, err := n.ListenPacket(address)
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func readPacket(t *testing.T, c net.PacketConn, buf []byte) (string, net.Addr) {
+	t.Helper()
+	n, from, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	return string(buf[:n]), from
+}
@@ -0,0 +1,208 @@
+package testlistener
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// pcapng block types and constants, see the PCAP Next Generation (pcapng)
+// capture file format specification.
+const (
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+
+	linkTypeRaw = 101 // raw IPv4 or IPv6 packets
+	snapLen     = 0   // no limit
+)
+
+// TCP flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// WritePcapng writes the captured traffic to w in pcapng format, which
+// Wireshark and tcpdump can read. Every connection is framed as a TCP stream,
+// complete with the handshake and FINs. The connections, whose addresses
+// aren't IPv4 TCP addresses, get synthesized ones.
+func (c *Capture) WritePcapng(w io.Writer) error {
+	pw := &pcapngWriter{w: w}
+	pw.writeBlock(pcapngSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, pcapngByteOrderMagic)
+		b = binary.LittleEndian.AppendUint16(b, 1)                  // major version
+		b = binary.LittleEndian.AppendUint16(b, 0)                  // minor version
+		b = binary.LittleEndian.AppendUint64(b, 0xffffffffffffffff) // unknown section length
+		return b
+	})
+	pw.writeBlock(pcapngInterfaceDesc, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, linkTypeRaw)
+		b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+		b = binary.LittleEndian.AppendUint32(b, snapLen)
+		return b
+	})
+
+	streams := make(map[int]*tcpStream)
+	for _, r := range c.Records() {
+		s := streams[r.Conn]
+		if s == nil {
+			s = newTCPStream(r)
+			streams[r.Conn] = s
+		}
+		for _, pkt := range s.packets(r) {
+			pw.writePacket(r, pkt)
+		}
+	}
+	return pw.err
+}
+
+type pcapngWriter struct {
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+func (pw *pcapngWriter) writeBlock(typ uint32, body func(b []byte) []byte) {
+	if pw.err != nil {
+		return
+	}
+	b := binary.LittleEndian.AppendUint32(pw.buf[:0], typ)
+	b = binary.LittleEndian.AppendUint32(b, 0) // length, set below
+	b = body(b)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	length := uint32(len(b) + 4)
+	binary.LittleEndian.PutUint32(b[4:], length)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	pw.buf = b
+	_, pw.err = pw.w.Write(b)
+}
+
+func (pw *pcapngWriter) writePacket(r Record, pkt []byte) {
+	// The default timestamp resolution is microseconds
+	ts := uint64(r.Time.UnixMicro())
+	pw.writeBlock(pcapngEnhancedPacket, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0) // interface ID
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(pkt))) // captured length
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(pkt))) // original length
+		return append(b, pkt...)
+	})
+}
+
+// tcpStream synthesizes TCP/IPv4 packets for the records of a connection.
+type tcpStream struct {
+	client, server tcpEndpoint
+}
+
+type tcpEndpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32 // next sequence number to send
+}
+
+func newTCPStream(r Record) *tcpStream {
+	s := &tcpStream{
+		client: tcpEndpoint{ip: net.IPv4(10, 0, 0, 2), port: uint16(32768 + r.Conn%32768)},
+		server: tcpEndpoint{ip: net.IPv4(10, 0, 0, 1), port: 80},
+	}
+	if a, ok := r.Client.(*net.TCPAddr); ok && a.IP.To4() != nil {
+		s.client.ip, s.client.port = a.IP, uint16(a.Port)
+	}
+	if a, ok := r.Server.(*net.TCPAddr); ok && a.IP.To4() != nil {
+		s.server.ip, s.server.port = a.IP, uint16(a.Port)
+	}
+	return s
+}
+
+// packets returns the IP packets, which the record is framed into.
+func (s *tcpStream) packets(r Record) [][]byte {
+	src, dst := &s.client, &s.server
+	if !r.FromClient {
+		src, dst = dst, src
+	}
+
+	switch r.Kind {
+	case RecordOpen:
+		return [][]byte{
+			s.segment(src, dst, tcpSYN, nil),
+			s.segment(dst, src, tcpSYN|tcpACK, nil),
+			s.segment(src, dst, tcpACK, nil),
+		}
+	case RecordData:
+		var pkts [][]byte
+		for data := r.Data; len(data) > 0; {
+			n := min(len(data), maxSegmentSize)
+			pkts = append(pkts, s.segment(src, dst, tcpPSH|tcpACK, data[:n]))
+			data = data[n:]
+		}
+		return pkts
+	case RecordClose:
+		return [][]byte{
+			s.segment(src, dst, tcpFIN|tcpACK, nil),
+		}
+	}
+	return nil
+}
+
+// segment builds an IPv4 packet with a TCP segment from src to dst,
+// advancing src's sequence number.
+func (s *tcpStream) segment(src, dst *tcpEndpoint, flags byte, data []byte) []byte {
+	const ipHeaderLen, tcpHeaderLen = 20, 20
+	pkt := make([]byte, ipHeaderLen+tcpHeaderLen+len(data))
+
+	ip := pkt[:ipHeaderLen]
+	ip[0] = 0x45 // version 4, header length of 5 words
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(pkt)))
+	ip[8] = 64 // TTL
+	ip[9] = 6  // TCP
+	copy(ip[12:16], src.ip.To4())
+	copy(ip[16:20], dst.ip.To4())
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	tcp := pkt[ipHeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], src.seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], dst.seq)
+	}
+	tcp[12] = tcpHeaderLen / 4 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[tcpHeaderLen:], data)
+
+	// The checksum covers the pseudo header: addresses, protocol and TCP length
+	var pseudo uint32
+	for _, b := range [][]byte{ip[12:16], ip[16:20]} {
+		pseudo += uint32(binary.BigEndian.Uint16(b[0:])) + uint32(binary.BigEndian.Uint16(b[2:]))
+	}
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudo))
+
+	src.seq += uint32(len(data))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		src.seq++
+	}
+	return pkt
+}
+
+// checksum computes the Internet checksum of b, see RFC 1071.
+func checksum(b []byte, sum uint32) uint16 {
+	for ; len(b) > 1; b = b[2:] {
+		sum += uint32(binary.BigEndian.Uint16(b))
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,322 @@
+package testlistener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ net.Listener = (*ProxyListener)(nil)
+
+// ProxyVersion is the version of the PROXY protocol, which load balancers
+// use to pass the addresses of the original connection to the server.
+type ProxyVersion int
+
+const (
+	// ProxyV1 is the human-readable version of the protocol.
+	ProxyV1 ProxyVersion = 1
+	// ProxyV2 is the binary version of the protocol.
+	ProxyV2 ProxyVersion = 2
+)
+
+// proxyV2Sig is the signature, which starts the header of PROXY protocol v2.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLen is the maximum length of the PROXY protocol v1 header.
+const proxyV1MaxLen = 107
+
+// ErrInvalidProxyHeader is returned by the connections of a ProxyListener,
+// which don't start with a valid PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// WithProxyHeader makes the client end of a connection send the PROXY protocol
+// header, carrying the src and dst addresses, before Connect returns, as if
+// the connection came through a load balancer. The header carries
+// the addresses, if both of them are *net.TCPAddr of the same IP family.
+// Otherwise, the header tells the addresses are unknown.
+//
+// The header is sent like any other data of the client, so it's captured and
+// counted in the stats. Unless the connection is created with WithBuffer,
+// it's buffered, so sending the header doesn't wait for Accept.
+func WithProxyHeader(version ProxyVersion, src, dst net.Addr) Option {
+	header := appendProxyHeader(nil, version, src, dst)
+	return func(cfg *config) {
+		cfg.proxyHeader = header
+	}
+}
+
+// appendProxyHeader appends the PROXY protocol header of the given version to b.
+func appendProxyHeader(b []byte, version ProxyVersion, src, dst net.Addr) []byte {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	known := sok && dok && (s.IP.To4() != nil) == (d.IP.To4() != nil)
+
+	switch version {
+	case ProxyV1:
+		if !known {
+			return append(b, "PROXY UNKNOWN\r\n"...)
+		}
+		proto := "TCP6"
+		if s.IP.To4() != nil {
+			proto = "TCP4"
+		}
+		return fmt.Appendf(b, "PROXY %s %s %s %d %d\r\n", proto, s.IP, d.IP, s.Port, d.Port)
+	case ProxyV2:
+		b = append(b, proxyV2Sig...)
+		b = append(b, 0x21) // version 2, PROXY command
+		switch {
+		case !known:
+			b = append(b, 0x00, 0, 0) // AF_UNSPEC without addresses
+		case s.IP.To4() != nil:
+			b = append(b, 0x11) // TCP over IPv4
+			b = binary.BigEndian.AppendUint16(b, 12)
+			b = append(b, s.IP.To4()...)
+			b = append(b, d.IP.To4()...)
+		default:
+			b = append(b, 0x21) // TCP over IPv6
+			b = binary.BigEndian.AppendUint16(b, 36)
+			b = append(b, s.IP.To16()...)
+			b = append(b, d.IP.To16()...)
+		}
+		if known {
+			b = binary.BigEndian.AppendUint16(b, uint16(s.Port))
+			b = binary.BigEndian.AppendUint16(b, uint16(d.Port))
+		}
+		return b
+	}
+	panic(fmt.Sprintf("testlistener: unknown PROXY protocol version %d", version))
+}
+
+// ProxyListener wraps a net.Listener, whose connections start with
+// a PROXY protocol v1 or v2 header. The accepted connections report
+// the addresses from the header as their RemoteAddr and LocalAddr.
+//
+// A connection reads the header on the first call to Read, so a slow client
+// doesn't block Accept. Until then, RemoteAddr and LocalAddr report
+// the connection's own addresses. If the header is invalid, or it isn't
+// received within the header timeout, Read fails. If the header doesn't
+// carry the addresses, the connection keeps reporting its own ones.
+type ProxyListener struct {
+	net.Listener
+	headerTimeout time.Duration
+}
+
+// defaultProxyHeaderTimeout is the default timeout of reading the PROXY protocol header.
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// ProxyOption configures a ProxyListener.
+type ProxyOption func(*ProxyListener)
+
+// WithHeaderTimeout sets how long a connection waits for the PROXY protocol
+// header, after which Read fails with os.ErrDeadlineExceeded. The default
+// is 5 seconds. A zero timeout waits for the header forever.
+func WithHeaderTimeout(timeout time.Duration) ProxyOption {
+	return func(pl *ProxyListener) {
+		pl.headerTimeout = timeout
+	}
+}
+
+// NewProxyListener wraps the listener to parse the PROXY protocol headers
+// of its connections.
+func NewProxyListener(l net.Listener, opts ...ProxyOption) *ProxyListener {
+	pl := &ProxyListener{Listener: l, headerTimeout: defaultProxyHeaderTimeout}
+	for _, opt := range opts {
+		opt(pl)
+	}
+	return pl
+}
+
+func (pl *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, timeout: pl.headerTimeout}, nil
+}
+
+// proxyConn wraps a net.Conn, which starts with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	r       *bufio.Reader
+	err     error
+
+	mu        sync.Mutex
+	src, dst  net.Addr
+	rdeadline time.Time // the read deadline, set by the conn's user
+}
+
+// init reads the header, once, under the header timeout.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		if c.timeout > 0 {
+			c.mu.Lock()
+			deadline := time.Now().Add(c.timeout)
+			if !c.rdeadline.IsZero() && c.rdeadline.Before(deadline) {
+				deadline = c.rdeadline
+			}
+			c.mu.Unlock()
+			c.Conn.SetReadDeadline(deadline)
+		}
+
+		src, dst, err := readProxyHeader(c.r)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(c.rdeadline)
+		}
+		c.src, c.dst, c.err = src, dst, err
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) CloseRead() error  { return closeRead(c.Conn) }
+func (c *proxyConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+// readProxyHeader reads the PROXY protocol header of either version from r.
+// The addresses are nil, if the header doesn't carry them.
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	sig, err := r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	if len(line) > proxyV1MaxLen || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: %.*q", ErrInvalidProxyHeader, proxyV1MaxLen, line)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown protocol %q", ErrInvalidProxyHeader, fields[1])
+	}
+
+	parse := func(host, port string) (*net.TCPAddr, error) {
+		ip := net.ParseIP(host)
+		p, err := strconv.ParseUint(port, 10, 16)
+		if ip == nil || err != nil {
+			return nil, fmt.Errorf("%w: invalid address %s %s", ErrInvalidProxyHeader, host, port)
+		}
+		return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+	}
+	s, err := parse(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := parse(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, d, nil
+}
+
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unknown version %d", ErrInvalidProxyHeader, hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	// The LOCAL command is sent by the load balancer itself, e.g. with health checks
+	if hdr[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+
+	var ipLen int
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		// Other families don't map to TCP addresses
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: short address block", ErrInvalidProxyHeader)
+	}
+	s := &net.TCPAddr{
+		IP:   net.IP(body[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+	}
+	d := &net.TCPAddr{
+		IP:   net.IP(body[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+	}
+	return s, d, nil
+}
@@ -0,0 +1,136 @@
+package testlistener
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProxyListener(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+
+	tests := []struct {
+		name     string
+		version  ProxyVersion
+		src, dst net.Addr
+		wantSrc  string
+		wantDst  string
+	}{
+		{"v1 tcp4", ProxyV1, v4src, v4dst, "203.0.113.7:51234", "10.0.0.1:443"},
+		{"v1 tcp6", ProxyV1, v6src, v6dst, "[2001:db8::7]:51234", "[2001:db8::1]:443"},
+		{"v1 unknown", ProxyV1, memAddr("lb"), v4dst, "pipe", "pipe"},
+		{"v2 tcp4", ProxyV2, v4src, v4dst, "203.0.113.7:51234", "10.0.0.1:443"},
+		{"v2 tcp6", ProxyV2, v6src, v6dst, "[2001:db8::7]:51234", "[2001:db8::1]:443"},
+		{"v2 unspec", ProxyV2, v4src, v6dst, "pipe", "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var capture Capture
+			l := NewListener(WithBacklog(1), WithBuffer(1024), WithCapture(&capture))
+			t.Cleanup(func() {
+				l.Close()
+			})
+			pl := NewProxyListener(l)
+
+			client, err := l.Connect(WithProxyHeader(tt.version, tt.src, tt.dst))
+			if err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			defer client.Close()
+			io.WriteString(client, "hello")
+			client.Close()
+
+			server, err := pl.Accept()
+			if err != nil {
+				t.Fatalf("Accept failed: %v", err)
+			}
+			defer server.Close()
+
+			// The client's data follows the header
+			got, err := io.ReadAll(server)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if string(got) != "hello" {
+				t.Errorf("Read got %q, want %q", got, "hello")
+			}
+			if got := server.RemoteAddr().String(); got != tt.wantSrc {
+				t.Errorf("RemoteAddr() = %q, want %q", got, tt.wantSrc)
+			}
+			if got := server.LocalAddr().String(); got != tt.wantDst {
+				t.Errorf("LocalAddr() = %q, want %q", got, tt.wantDst)
+			}
+
+			// The header is sent by the client like the rest of its data
+			header := appendProxyHeader(nil, tt.version, tt.src, tt.dst)
+			want := int64(len(header) + len("hello"))
+			if s := l.ConnStats()[0]; s.ClientWritten != want || s.ServerRead != want {
+				t.Errorf("got byte counts %+v, want %d bytes from client", s, want)
+			}
+			if r := capture.Records()[1]; r.Kind != RecordData || !r.FromClient || !bytes.Equal(r.Data, header) {
+				t.Errorf("got first captured data %+v, want header %q", r, header)
+			}
+		})
+	}
+}
+
+func TestProxyListener_NoHeader(t *testing.T) {
+	l := NewListener(WithBacklog(1), WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+	pl := NewProxyListener(l)
+
+	client, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET / HTTP/1.1\r\n\r\n")
+
+	server, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	if _, err := server.Read(make([]byte, 16)); !errors.Is(err, ErrInvalidProxyHeader) {
+		t.Errorf("Read got error %v, want %v", err, ErrInvalidProxyHeader)
+	}
+}
+
+func TestProxyListener_HeaderTimeout(t *testing.T) {
+	l := NewListener(WithBacklog(1), WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+	pl := NewProxyListener(l, WithHeaderTimeout(10*time.Millisecond))
+
+	// The client connects, but doesn't send anything
+	client, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Close()
+
+	server, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	// The addresses don't wait for the header
+	if got, want := server.RemoteAddr(), client.LocalAddr(); got != want {
+		t.Errorf("RemoteAddr() = %v, want %v", got, want)
+	}
+	if _, err := server.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
@@ -0,0 +1,205 @@
+package testlistener
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// defaultStepTimeout is the timeout of the script's steps, which don't set one.
+const defaultStepTimeout = 5 * time.Second
+
+// Step is a single step of a scripted conversation, see RunScript.
+type Step struct {
+	kind    stepKind
+	data    []byte
+	pattern *regexp.Regexp
+	timeout time.Duration
+}
+
+type stepKind int
+
+const (
+	stepSend stepKind = iota
+	stepExpectLine
+	stepExpectEOF
+)
+
+// Send writes the data to the connection.
+func Send(data string) Step {
+	return Step{kind: stepSend, data: []byte(data)}
+}
+
+// ExpectLine reads lines until one of them matches the pattern, failing
+// if no line matches within the timeout or the peer closes the connection.
+// The line is matched without its "\n" or "\r\n" ending.
+// A zero timeout means 5 seconds.
+func ExpectLine(pattern string, timeout time.Duration) Step {
+	return Step{kind: stepExpectLine, pattern: regexp.MustCompile(pattern), timeout: timeout}
+}
+
+// ExpectEOF expects the peer to close the connection, or its writing side,
+// within the timeout without sending any more data.
+// A zero timeout means 5 seconds.
+func ExpectEOF(timeout time.Duration) Step {
+	return Step{kind: stepExpectEOF, timeout: timeout}
+}
+
+func (s Step) String() string {
+	switch s.kind {
+	case stepSend:
+		return fmt.Sprintf("Send(%q)", s.data)
+	case stepExpectLine:
+		return fmt.Sprintf("ExpectLine(%q)", s.pattern)
+	case stepExpectEOF:
+		return "ExpectEOF()"
+	}
+	return "unknown step"
+}
+
+// RunScript connects to the listener and runs the steps of the conversation
+// over the connection, see the package-level RunScript.
+// Unless the listener was created with WithBuffer, the connection is buffered,
+// so the script can send data while the server is writing its own.
+func (l *Listener) RunScript(tb testing.TB, steps ...Step) {
+	tb.Helper()
+	var opts []Option
+	if l.cfg.bufSize == 0 {
+		opts = append(opts, WithBuffer(defaultBufSize))
+	}
+	conn, err := l.Connect(opts...)
+	if err != nil {
+		tb.Fatalf("testlistener: connect failed: %v", err)
+	}
+	defer conn.Close()
+	RunScript(tb, conn, steps...)
+}
+
+// RunScript runs the steps of the conversation over the connection one by one,
+// the way the expect tool does. It stops the test with tb.Fatalf at the first
+// failed step, and reports the conversation so far, where the sent data is
+// marked with ">", the received data with "<", and the failed expectation
+// as a diff of what was wanted ("-") and what was received ("+").
+// Like tb.Fatalf, it must be called from the goroutine running the test.
+func RunScript(tb testing.TB, conn net.Conn, steps ...Step) {
+	tb.Helper()
+	s := &scriptRun{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	for i, step := range steps {
+		if err := s.run(step); err != nil {
+			tb.Fatalf("testlistener: step %d %s failed: %v\nconversation:\n%s", i+1, step, err, s.log.String())
+		}
+	}
+}
+
+// scriptRun is the state of a running script.
+type scriptRun struct {
+	conn net.Conn
+	r    *bufio.Reader
+	log  strings.Builder
+}
+
+func (s *scriptRun) run(step Step) error {
+	timeout := step.timeout
+	if timeout == 0 {
+		timeout = defaultStepTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	switch step.kind {
+	case stepSend:
+		s.logData('>', step.data)
+		s.conn.SetWriteDeadline(deadline)
+		defer s.conn.SetWriteDeadline(time.Time{})
+		_, err := s.conn.Write(step.data)
+		return err
+
+	case stepExpectLine:
+		s.conn.SetReadDeadline(deadline)
+		defer s.conn.SetReadDeadline(time.Time{})
+		var skipped []string
+		for {
+			line, err := s.r.ReadString('\n')
+			if err == nil && step.pattern.MatchString(strings.TrimRight(line, "\r\n")) {
+				for _, l := range skipped {
+					s.logLine('<', l)
+				}
+				s.logLine('<', line)
+				return nil
+			}
+			if line != "" {
+				skipped = append(skipped, line)
+			}
+			if err != nil {
+				fmt.Fprintf(&s.log, "\t- /%s/\n", step.pattern)
+				for _, l := range skipped {
+					s.logLine('+', l)
+				}
+				s.logErr('+', err)
+				return readErr(err, timeout)
+			}
+		}
+
+	case stepExpectEOF:
+		s.conn.SetReadDeadline(deadline)
+		defer s.conn.SetReadDeadline(time.Time{})
+		data, err := io.ReadAll(s.r)
+		if err == nil && len(data) == 0 {
+			s.logErr('<', io.EOF)
+			return nil
+		}
+		s.log.WriteString("\t- <EOF>\n")
+		s.logData('+', data)
+		if err != nil {
+			s.logErr('+', err)
+			return readErr(err, timeout)
+		}
+		s.logErr('+', io.EOF)
+		return fmt.Errorf("got %d bytes before EOF", len(data))
+	}
+	return fmt.Errorf("unknown step")
+}
+
+// readErr describes the error, which interrupted an expectation.
+func readErr(err error, timeout time.Duration) error {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return fmt.Errorf("timed out after %v", timeout)
+	case err == io.EOF:
+		return fmt.Errorf("unexpected EOF")
+	}
+	return err
+}
+
+// logData adds the data to the conversation log, line by line.
+func (s *scriptRun) logData(mark byte, data []byte) {
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" {
+			s.logLine(mark, line)
+		}
+	}
+}
+
+func (s *scriptRun) logLine(mark byte, line string) {
+	fmt.Fprintf(&s.log, "\t%c %q\n", mark, line)
+}
+
+func (s *scriptRun) logErr(mark byte, err error) {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		fmt.Fprintf(&s.log, "\t%c <timeout>\n", mark)
+	case err == io.EOF:
+		fmt.Fprintf(&s.log, "\t%c <EOF>\n", mark)
+	default:
+		fmt.Fprintf(&s.log, "\t%c <%v>\n", mark, err)
+	}
+}
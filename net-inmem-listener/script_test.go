@@ -0,0 +1,130 @@
+package testlistener
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveGreeter replies to "HELO name" lines with "250 hello name" and
+// closes the connection on "QUIT".
+func serveGreeter(t *testing.T, l *Listener) {
+	t.Helper()
+	t.Cleanup(func() {
+		l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write([]byte("220 greeter ready\r\n"))
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					cmd, arg, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+					switch cmd {
+					case "HELO":
+						conn.Write([]byte("250 hello " + arg + "\r\n"))
+					case "QUIT":
+						conn.Write([]byte("221 bye\r\n"))
+						return
+					default:
+						conn.Write([]byte("500 unknown command\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestRunScript(t *testing.T) {
+	l := NewListener()
+	serveGreeter(t, l)
+
+	l.RunScript(t,
+		ExpectLine(`^220 `, time.Second),
+		Send("HELO test\r\n"),
+		ExpectLine(`^250 hello test$`, time.Second),
+		Send("QUIT\r\n"),
+		ExpectLine(`^221`, time.Second),
+		ExpectEOF(time.Second),
+	)
+}
+
+func TestRunScript_Failure(t *testing.T) {
+	l := NewListener()
+	serveGreeter(t, l)
+
+	tests := []struct {
+		name  string
+		steps []Step
+		want  []string
+	}{
+		{
+			name: "mismatch",
+			steps: []Step{
+				Send("EHLO test\r\n"),
+				ExpectLine(`^250 `, 50*time.Millisecond),
+			},
+			want: []string{
+				`step 2 ExpectLine("^250 ") failed: timed out after 50ms`,
+				`> "EHLO test\r\n"`,
+				`- /^250 /`,
+				`+ "220 greeter ready\r\n"`,
+				`+ "500 unknown command\r\n"`,
+				`+ <timeout>`,
+			},
+		},
+		{
+			name: "unexpected EOF",
+			steps: []Step{
+				Send("QUIT\r\n"),
+				ExpectLine(`^250 `, time.Second),
+			},
+			want: []string{
+				`failed: unexpected EOF`,
+				`+ "221 bye\r\n"`,
+				`+ <EOF>`,
+			},
+		},
+		{
+			name: "data before EOF",
+			steps: []Step{
+				ExpectLine(`^220 `, time.Second),
+				Send("HELO test\r\nQUIT\r\n"),
+				ExpectEOF(time.Second),
+			},
+			want: []string{
+				`step 3 ExpectEOF() failed: got 25 bytes before EOF`,
+				`< "220 greeter ready\r\n"`,
+				`- <EOF>`,
+				`+ "250 hello test\r\n"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &fakeTB{TB: t}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				l.RunScript(tb, tt.steps...)
+			}()
+			<-done
+
+			if len(tb.errors) != 1 {
+				t.Fatalf("got errors %q, want one", tb.errors)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(tb.errors[0], want) {
+					t.Errorf("error doesn't contain %q:\n%s", want, tb.errors[0])
+				}
+			}
+		})
+	}
+}
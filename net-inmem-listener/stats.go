@@ -0,0 +1,134 @@
+package testlistener
+
+import (
+	"net"
+	"time"
+)
+
+// ConnStats are the statistics of a single connection.
+type ConnStats struct {
+	// Seq is the sequence number of the connection in its listener.
+	Seq int
+	// Opened is when Connect created the connection, Accepted is when Accept
+	// returned it, and Closed is when both of its ends were closed.
+	// The time is zero, if the event hasn't happened yet.
+	Opened   time.Time
+	Accepted time.Time
+	Closed   time.Time
+	// The number of bytes read and written by each end.
+	ClientRead    int64
+	ClientWritten int64
+	ServerRead    int64
+	ServerWritten int64
+}
+
+// AcceptWait returns how long the connection waited for Accept.
+// It's zero if the connection hasn't been accepted.
+func (s ConnStats) AcceptWait() time.Duration {
+	if s.Accepted.IsZero() {
+		return 0
+	}
+	return s.Accepted.Sub(s.Opened)
+}
+
+// Stats are the aggregate statistics of all connections of a listener.
+type Stats struct {
+	// Conns is the number of connections created by Connect.
+	Conns int
+	// Accepted is the number of the connections returned by Accept.
+	Accepted int
+	// Active is the number of connections with an open end.
+	Active int
+	// The total number of bytes read and written by the ends of connections.
+	ClientRead    int64
+	ClientWritten int64
+	ServerRead    int64
+	ServerWritten int64
+	// MaxAcceptWait is the longest time a connection waited for Accept.
+	MaxAcceptWait time.Duration
+}
+
+// ConnStats returns the statistics of all connections of the listener,
+// ordered by their sequence numbers. The listener keeps the statistics of
+// every connection, including the closed ones, until it's garbage collected,
+// so its memory grows with the number of connections. It's meant for tests,
+// rather than for long-lived servers with many connections.
+func (l *Listener) ConnStats() []ConnStats {
+	l.mu.Lock()
+	history := append([]*connPair(nil), l.history...)
+	l.mu.Unlock()
+
+	stats := make([]ConnStats, 0, len(history))
+	for _, p := range history {
+		stats = append(stats, p.stats())
+	}
+	return stats
+}
+
+// Stats returns the aggregate statistics of all connections of the listener.
+func (l *Listener) Stats() Stats {
+	var stats Stats
+	for _, s := range l.ConnStats() {
+		stats.Conns++
+		if !s.Accepted.IsZero() {
+			stats.Accepted++
+		}
+		if s.Closed.IsZero() {
+			stats.Active++
+		}
+		stats.ClientRead += s.ClientRead
+		stats.ClientWritten += s.ClientWritten
+		stats.ServerRead += s.ServerRead
+		stats.ServerWritten += s.ServerWritten
+		stats.MaxAcceptWait = max(stats.MaxAcceptWait, s.AcceptWait())
+	}
+	return stats
+}
+
+func (p *connPair) stats() ConnStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ConnStats{
+		Seq:           p.seq,
+		Opened:        p.opened,
+		Accepted:      p.accepted,
+		Closed:        p.closed,
+		ClientRead:    p.client.nread.Load(),
+		ClientWritten: p.client.nwritten.Load(),
+		ServerRead:    p.server.nread.Load(),
+		ServerWritten: p.server.nwritten.Load(),
+	}
+}
+
+// WithOnConnect sets the function, which is called with the client end
+// of every connection, once Connect succeeds.
+func WithOnConnect(f func(client net.Conn)) Option {
+	return func(cfg *config) {
+		cfg.onConnect = f
+	}
+}
+
+// WithOnAccept sets the function, which is called with the server end
+// of every connection, before Accept returns it.
+func WithOnAccept(f func(server net.Conn)) Option {
+	return func(cfg *config) {
+		cfg.onAccept = f
+	}
+}
+
+// WithOnClose sets the function, which is called with the final statistics
+// of every connection, once both of its ends are closed.
+func WithOnClose(f func(stats ConnStats)) Option {
+	return func(cfg *config) {
+		cfg.onClose = f
+	}
+}
+
+// WithConnWrapper adds a middleware, which wraps the client and server ends
+// of every connection, for example, to log or alter the traffic.
+// The wrappers are applied in the order they were added.
+func WithConnWrapper(wrap func(client, server net.Conn) (net.Conn, net.Conn)) Option {
+	return func(cfg *config) {
+		cfg.wrappers = append(cfg.wrappers, wrap)
+	}
+}
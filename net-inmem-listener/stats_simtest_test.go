@@ -0,0 +1,36 @@
+//go:build simtest
+
+package testlistener
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestListener_StatsAcceptWait(t *testing.T) {
+	synctest.Run(func() {
+		l := NewListener(WithBacklog(1))
+		defer l.Close()
+
+		client, err := l.Connect()
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer client.Close()
+
+		time.Sleep(10 * time.Millisecond)
+		server, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+		defer server.Close()
+
+		if got, want := l.ConnStats()[0].AcceptWait(), 10*time.Millisecond; got != want {
+			t.Errorf("AcceptWait() = %v, want %v", got, want)
+		}
+		if got, want := l.Stats().MaxAcceptWait, 10*time.Millisecond; got != want {
+			t.Errorf("MaxAcceptWait = %v, want %v", got, want)
+		}
+	})
+}
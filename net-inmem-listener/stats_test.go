@@ -0,0 +1,104 @@
+package testlistener
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestListener_Stats(t *testing.T) {
+	l := NewListener(WithBacklog(1), WithBuffer(1024))
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, err := l.Connect()
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	server.Write([]byte("pong!"))
+	client.Write([]byte("ping"))
+	io.ReadFull(server, make([]byte, 4))
+	io.ReadFull(client, make([]byte, 5))
+	client.Close()
+
+	stats := l.ConnStats()
+	if len(stats) != 1 {
+		t.Fatalf("got %d conn stats, want %d", len(stats), 1)
+	}
+	s := stats[0]
+	if s.ClientWritten != 4 || s.ServerRead != 4 || s.ServerWritten != 5 || s.ClientRead != 5 {
+		t.Errorf("got byte counts %+v, want 4 bytes from client and 5 bytes from server", s)
+	}
+	if !s.Closed.IsZero() {
+		t.Errorf("Closed = %v, want zero for the conn with the open server end", s.Closed)
+	}
+
+	server.Close()
+	got := l.Stats()
+	if got.Conns != 1 || got.Accepted != 1 || got.Active != 0 {
+		t.Errorf("got Stats %+v, want one accepted and closed conn", got)
+	}
+	if got.ClientWritten != 4 || got.ServerWritten != 5 {
+		t.Errorf("got Stats %+v, want 4 bytes from client and 5 bytes from server", got)
+	}
+}
+
+func TestListener_Hooks(t *testing.T) {
+	var (
+		connected, accepted atomic.Int32
+		closed              = make(chan ConnStats, 1)
+		wrapped             atomic.Int32
+	)
+	l := NewListener(
+		WithOnConnect(func(net.Conn) { connected.Add(1) }),
+		WithOnAccept(func(net.Conn) { accepted.Add(1) }),
+		WithOnClose(func(s ConnStats) { closed <- s }),
+		WithConnWrapper(func(client, server net.Conn) (net.Conn, net.Conn) {
+			wrapped.Add(1)
+			return client, &upperConn{server}
+		}),
+	)
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	client, server := connectPair(t, l)
+	if connected.Load() != 1 || accepted.Load() != 1 || wrapped.Load() != 1 {
+		t.Errorf("got %d connect, %d accept and %d wrap calls, want one each", connected.Load(), accepted.Load(), wrapped.Load())
+	}
+
+	go client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	io.ReadFull(server, buf)
+	if string(buf) != "HELLO" {
+		t.Errorf("Read %q through the wrapper, want %q", buf, "HELLO")
+	}
+
+	client.Close()
+	server.Close()
+	if s := <-closed; s.Seq != 1 || s.ServerRead != 5 {
+		t.Errorf("OnClose got %+v, want stats of conn 1", s)
+	}
+}
+
+// upperConn upper-cases ASCII letters it reads.
+type upperConn struct {
+	net.Conn
+}
+
+func (c *upperConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := range b[:n] {
+		if 'a' <= b[i] && b[i] <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,218 @@
+package testlistener
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	_ net.Listener = (*TLSListener)(nil)
+	_ Dialer       = (*TLSListener)(nil)
+)
+
+// TLSListener wraps a Listener to secure its connections with TLS.
+// The certificates are generated in memory, when the listener is created.
+//
+// A TLS 1.3 server sends session tickets without waiting for the client,
+// which deadlocks synchronous connections. So, unless the listener was
+// created with WithBuffer, TLSListener makes its connections buffered.
+type TLSListener struct {
+	net.Listener
+	raw *Listener
+
+	// CA is the certificate authority, which issued all certificates.
+	CA *x509.Certificate
+	// ServerConfig is the configuration of the server ends of connections.
+	ServerConfig *tls.Config
+	// ClientConfig is the configuration of the client ends of connections.
+	// It trusts CA and, with WithClientCert, presents a client certificate.
+	ClientConfig *tls.Config
+}
+
+// TLSOption configures a TLSListener.
+type TLSOption func(*tlsOptions)
+
+type tlsOptions struct {
+	hosts      []string
+	clientCert bool
+}
+
+// WithHosts sets the DNS names and IP addresses, which the server certificate
+// is valid for. The first host is the default server name of the client.
+// By default, the certificate is valid for "example.com", "localhost",
+// "127.0.0.1" and "::1". WithHosts without hosts keeps the default ones.
+func WithHosts(hosts ...string) TLSOption {
+	return func(opts *tlsOptions) {
+		if len(hosts) > 0 {
+			opts.hosts = hosts
+		}
+	}
+}
+
+// WithClientCert makes the server require a client certificate, issued by
+// the listener's CA, and the client present one, as with mutual TLS.
+func WithClientCert() TLSOption {
+	return func(opts *tlsOptions) {
+		opts.clientCert = true
+	}
+}
+
+// NewTLSListener wraps the listener to secure its connections with TLS.
+// It generates a new CA and a server certificate, and configures the client
+// to trust the CA.
+func NewTLSListener(l *Listener, opts ...TLSOption) (*TLSListener, error) {
+	o := tlsOptions{
+		hosts: []string{"example.com", "localhost", "127.0.0.1", "::1"},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ca, caKey, err := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "testlistener CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("generate CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	serverTmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: o.hosts[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range o.hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			serverTmpl.IPAddresses = append(serverTmpl.IPAddresses, ip)
+		} else {
+			serverTmpl.DNSNames = append(serverTmpl.DNSNames, h)
+		}
+	}
+	serverCert, _, err := newCertificate(serverTmpl, ca.Leaf, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("generate server certificate: %w", err)
+	}
+
+	tl := &TLSListener{
+		raw: l,
+		CA:  ca.Leaf,
+		ServerConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+		},
+		ClientConfig: &tls.Config{
+			RootCAs:    pool,
+			ServerName: o.hosts[0],
+		},
+	}
+
+	if o.clientCert {
+		clientCert, _, err := newCertificate(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "testlistener client"},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca.Leaf, caKey)
+		if err != nil {
+			return nil, fmt.Errorf("generate client certificate: %w", err)
+		}
+		tl.ServerConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tl.ServerConfig.ClientCAs = pool
+		tl.ClientConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	tl.Listener = tls.NewListener(l, tl.ServerConfig)
+	return tl, nil
+}
+
+// Connect connects to the listener and returns the client end of a TLS
+// connection. The handshake happens on the first Read or Write.
+func (tl *TLSListener) Connect(opts ...Option) (*tls.Conn, error) {
+	return tl.ConnectContext(context.Background(), opts...)
+}
+
+// ConnectContext is like Connect but aborts waiting for Accept when ctx is done.
+func (tl *TLSListener) ConnectContext(ctx context.Context, opts ...Option) (*tls.Conn, error) {
+	conn, err := tl.connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Client(conn, tl.ClientConfig), nil
+}
+
+// DialContext connects to the listener and completes the TLS handshake.
+// The host of the address, if any, is sent as the server name (SNI),
+// so the method can be used as http.Transport.DialTLSContext.
+func (tl *TLSListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := tl.connect(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := tl.ClientConfig
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connect creates a new raw connection, making it buffered if needed.
+func (tl *TLSListener) connect(ctx context.Context, opts []Option) (net.Conn, error) {
+	if tl.raw.cfg.bufSize == 0 {
+		opts = append([]Option{WithBuffer(defaultBufSize)}, opts...)
+	}
+	return tl.raw.ConnectContext(ctx, opts...)
+}
+
+// newCertificate generates a key pair and a certificate from the template,
+// signed by the parent. A nil parent makes the certificate self-signed.
+func newCertificate(tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(24 * time.Hour)
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	return cert, key, nil
+}
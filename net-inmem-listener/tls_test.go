@@ -0,0 +1,110 @@
+package testlistener
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestTLSListener_HTTP(t *testing.T) {
+	tl, err := NewTLSListener(NewListener(), WithHosts("api.example.com", "eu.example.com"))
+	if err != nil {
+		t.Fatalf("NewTLSListener failed: %v", err)
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.TLS.ServerName)
+		}),
+	}
+	go srv.Serve(tl)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialTLSContext: tl.DialContext,
+		},
+	}
+	for _, host := range []string{"api.example.com", "eu.example.com"} {
+		resp, err := client.Get("https://" + host + "/")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != host {
+			t.Errorf("got server name %q, want %q", body, host)
+		}
+	}
+
+	// The certificate isn't valid for other names
+	if _, err := client.Get("https://other.example.com/"); err == nil {
+		t.Error("Get with unknown server name succeeded")
+	}
+}
+
+func TestTLSListener_ClientCert(t *testing.T) {
+	tl, err := NewTLSListener(NewListener(), WithClientCert())
+	if err != nil {
+		t.Fatalf("NewTLSListener failed: %v", err)
+	}
+	t.Cleanup(func() {
+		tl.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				state := conn.(*tls.Conn).ConnectionState()
+				io.WriteString(conn, state.PeerCertificates[0].Subject.CommonName)
+			}()
+		}
+	}()
+
+	conn, err := tl.Connect()
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if want := "testlistener client"; string(got) != want {
+		t.Errorf("server got client certificate %q, want %q", got, want)
+	}
+	conn.Close()
+
+	// Without the client certificate the handshake fails
+	cfg := tl.ClientConfig.Clone()
+	cfg.Certificates = nil
+	raw, err := tl.connect(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer raw.Close()
+	if _, err := io.ReadAll(tls.Client(raw, cfg)); err == nil {
+		t.Error("Read without client certificate succeeded")
+	}
+}
+
+func TestTLSListener_NoHosts(t *testing.T) {
+	tl, err := NewTLSListener(NewListener(), WithHosts())
+	if err != nil {
+		t.Fatalf("NewTLSListener failed: %v", err)
+	}
+	if want := "example.com"; tl.ClientConfig.ServerName != want {
+		t.Errorf("got server name %q, want %q", tl.ClientConfig.ServerName, want)
+	}
+}
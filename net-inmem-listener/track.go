@@ -0,0 +1,180 @@
+package testlistener
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ActiveConns returns the number of the listener's connections,
+// which have at least one end open.
+func (l *Listener) ActiveConns() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pairs)
+}
+
+// CloseAll closes both ends of all connections of the listener.
+func (l *Listener) CloseAll() {
+	l.mu.Lock()
+	pairs := make([]*connPair, 0, len(l.pairs))
+	for p := range l.pairs {
+		pairs = append(pairs, p)
+	}
+	l.mu.Unlock()
+
+	for _, p := range pairs {
+		p.client.Close()
+		p.server.Close()
+	}
+}
+
+// Shutdown closes the listener and waits for all of its connections to be
+// closed. If ctx is done first, Shutdown returns ctx.Err().
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.Close()
+	for {
+		l.mu.Lock()
+		live, changed := len(l.pairs), l.changed
+		l.mu.Unlock()
+		if live == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// leakTimeout is how long TrackLeaks waits for connections to be closed.
+const leakTimeout = time.Second
+
+// TrackLeaks registers a test cleanup, which closes the listener and fails
+// the test if any of the listener's connections are still open. The cleanup
+// waits for a short time, so the connections, which are being closed by
+// other goroutines, aren't reported.
+func TrackLeaks(tb testing.TB, l *Listener) {
+	tb.Helper()
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), leakTimeout)
+		defer cancel()
+		if err := l.Shutdown(ctx); err != nil {
+			tb.Errorf("testlistener: %d connections are still open: %v", l.ActiveConns(), err)
+			l.CloseAll()
+		}
+	})
+}
+
+// track registers the connection in the listener and wraps both of its ends
+// to unregister it, once both ends are closed. It fails, and closes the conns,
+// if the listener reached its limit of live connections.
+func (l *Listener) track(cfg config, seq int, client, server net.Conn) (*trackedConn, *trackedConn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cfg.maxConns > 0 && len(l.pairs) >= l.cfg.maxConns {
+		client.Close()
+		server.Close()
+		return nil, nil, &LimitError{Limit: l.cfg.maxConns}
+	}
+
+	p := &connPair{
+		seq:      seq,
+		open:     2,
+		opened:   time.Now(),
+		release:  l.release,
+		onAccept: cfg.onAccept,
+		onClose:  cfg.onClose,
+	}
+	p.client = &trackedConn{Conn: client, pair: p}
+	p.server = &trackedConn{Conn: server, pair: p}
+	l.pairs[p] = struct{}{}
+	l.history = append(l.history, p)
+	return p.client, p.server, nil
+}
+
+func (l *Listener) release(p *connPair) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pairs, p)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// connPair tracks both ends of a connection.
+type connPair struct {
+	seq      int
+	client   *trackedConn
+	server   *trackedConn
+	release  func(p *connPair)
+	onAccept func(net.Conn)
+	onClose  func(ConnStats)
+
+	mu       sync.Mutex
+	open     int
+	opened   time.Time
+	accepted time.Time
+	closed   time.Time
+}
+
+func (p *connPair) accept() {
+	p.mu.Lock()
+	p.accepted = time.Now()
+	p.mu.Unlock()
+	if p.onAccept != nil {
+		p.onAccept(p.server)
+	}
+}
+
+func (p *connPair) closeEnd() {
+	p.mu.Lock()
+	p.open--
+	last := p.open == 0
+	if last {
+		p.closed = time.Now()
+	}
+	p.mu.Unlock()
+	if last {
+		p.release(p)
+		if p.onClose != nil {
+			p.onClose(p.stats())
+		}
+	}
+}
+
+// trackedConn wraps one end of a connection, reporting its Close to the pair
+// and counting the bytes read and written.
+type trackedConn struct {
+	net.Conn
+	pair     *connPair
+	once     sync.Once
+	nread    atomic.Int64
+	nwritten atomic.Int64
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.nread.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.nwritten.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.pair.closeEnd)
+	return err
+}
+
+func (c *trackedConn) CloseRead() error  { return closeRead(c.Conn) }
+func (c *trackedConn) CloseWrite() error { return closeWrite(c.Conn) }
@@ -0,0 +1,136 @@
+package testlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListener_CloseTwice(t *testing.T) {
+	l := NewListener()
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+}
+
+func TestListener_ActiveConns(t *testing.T) {
+	l := NewListener()
+	TrackLeaks(t, l)
+
+	client1, server1 := connectPair(t, l)
+	client2, server2 := connectPair(t, l)
+	if got := l.ActiveConns(); got != 2 {
+		t.Errorf("ActiveConns() = %d, want %d", got, 2)
+	}
+
+	client1.Close()
+	server1.Close()
+	if got := l.ActiveConns(); got != 1 {
+		t.Errorf("ActiveConns() = %d, want %d", got, 1)
+	}
+
+	l.CloseAll()
+	if got := l.ActiveConns(); got != 0 {
+		t.Errorf("ActiveConns() after CloseAll = %d, want %d", got, 0)
+	}
+	if _, err := client2.Write([]byte("x")); err == nil {
+		t.Error("Write to closed conn succeeded")
+	}
+	if _, err := server2.Read(make([]byte, 1)); err == nil {
+		t.Error("Read from closed conn succeeded")
+	}
+}
+
+func TestListener_Shutdown(t *testing.T) {
+	l := NewListener()
+
+	client, server := connectPair(t, l)
+
+	// Accept returns, once Shutdown has closed the listener, so the conns
+	// are closed while Shutdown is running.
+	go func() {
+		if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept got error %v, want %v", err, net.ErrClosed)
+		}
+		client.Close()
+		server.Close()
+	}()
+
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if got := l.ActiveConns(); got != 0 {
+		t.Errorf("ActiveConns() after Shutdown = %d, want %d", got, 0)
+	}
+	if _, err := l.Connect(); err == nil {
+		t.Error("Connect after Shutdown succeeded")
+	}
+}
+
+func TestListener_ShutdownTimeout(t *testing.T) {
+	l := NewListener()
+
+	client, server := connectPair(t, l)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTrackLeaks(t *testing.T) {
+	tb := &fakeTB{TB: t}
+
+	l := NewListener()
+	TrackLeaks(tb, l)
+	connectPair(t, l)
+
+	tb.cleanup()
+	if len(tb.errors) != 1 {
+		t.Fatalf("got errors %q, want one leak reported", tb.errors)
+	}
+	if got := l.ActiveConns(); got != 0 {
+		t.Errorf("ActiveConns() after cleanup = %d, want %d", got, 0)
+	}
+}
+
+// fakeTB records the test's errors and cleanups instead of running them.
+type fakeTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+// Fatalf records the error and stops the calling goroutine, as testing.TB does.
+func (tb *fakeTB) Fatalf(format string, args ...any) {
+	tb.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (tb *fakeTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+func (tb *fakeTB) cleanup() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}